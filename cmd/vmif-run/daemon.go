@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bocajspear1/vmifactory/internal/imagemanage"
+	"github.com/bocajspear1/vmifactory/internal/schedule"
+)
+
+// scheduleFile is the name of the persisted next run table in the image directory
+const scheduleFile = ".vmif-schedule.json"
+
+// daemon rebuilds images on their schedules until it is stopped
+type daemon struct {
+	imageDir string
+	options  buildOptions
+	table    *schedule.Table
+	slots    chan struct{}
+
+	runningLock sync.Mutex
+	running     map[string]bool
+	wg          sync.WaitGroup
+}
+
+// runDaemon checks the image schedules every minute and starts the images
+// that are due, running up to jobs builds at once
+func runDaemon(ctx context.Context, imageDir string, jobs int, options buildOptions) error {
+	table, terr := schedule.LoadTable(imageDir + "/" + scheduleFile)
+	if terr != nil {
+		return terr
+	}
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	d := &daemon{
+		imageDir: imageDir,
+		options:  options,
+		table:    table,
+		slots:    make(chan struct{}, jobs),
+		running:  make(map[string]bool),
+	}
+
+	log.Println("Starting scheduler daemon...")
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		d.checkSchedules(ctx)
+		select {
+		case <-ctx.Done():
+			log.Println("Stopping scheduler daemon, waiting for running builds...")
+			d.wg.Wait()
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *daemon) isRunning(imageName string) bool {
+	d.runningLock.Lock()
+	defer d.runningLock.Unlock()
+	return d.running[imageName]
+}
+
+func (d *daemon) setRunning(imageName string, running bool) {
+	d.runningLock.Lock()
+	defer d.runningLock.Unlock()
+	if running {
+		d.running[imageName] = true
+	} else {
+		delete(d.running, imageName)
+	}
+}
+
+// checkSchedules starts the builds of the images that are due
+func (d *daemon) checkSchedules(ctx context.Context) {
+	now := time.Now()
+	changed := false
+
+	for _, imageName := range imagemanage.GetAvailableImages(d.imageDir) {
+		if ctx.Err() != nil {
+			break
+		}
+		image, ierr := imagemanage.NewVMImage(d.imageDir, imageName)
+		if ierr != nil {
+			log.Println("Could not load '" + imageName + "': " + ierr.Error())
+			continue
+		}
+		spec, imageSchedule, serr := image.GetSchedule()
+		if serr != nil {
+			log.Println("Skipping '" + imageName + "': " + serr.Error())
+			continue
+		}
+		if imageSchedule == nil {
+			if d.table.Get(imageName) != nil {
+				d.table.Remove(imageName)
+				changed = true
+			}
+			continue
+		}
+
+		entry := d.table.Get(imageName)
+		if entry == nil || entry.Spec != spec {
+			// New or changed schedules count from the last run, if there was one
+			newEntry := schedule.Entry{Spec: spec, NextRun: imageSchedule.Next(now)}
+			if entry != nil && !entry.LastRun.IsZero() {
+				newEntry.LastRun = entry.LastRun
+				newEntry.NextRun = imageSchedule.Next(entry.LastRun)
+			}
+			d.table.Set(imageName, newEntry)
+			changed = true
+			entry = &newEntry
+			log.Println("Scheduled '" + imageName + "' (" + spec + "), next run at " + entry.NextRun.Format("2006-01-02 15:04"))
+		}
+
+		if entry.NextRun.IsZero() || now.Before(entry.NextRun) {
+			continue
+		}
+		if d.isRunning(imageName) {
+			continue
+		}
+		if image.IsLocked() {
+			log.Println("'" + imageName + "' is due but is already being built, will try again...")
+			continue
+		}
+
+		select {
+		case d.slots <- struct{}{}:
+		default:
+			// Every worker is busy, the build stays due until one is free
+			continue
+		}
+
+		if now.Sub(entry.NextRun) > time.Minute {
+			log.Println("Catching up on missed run of '" + imageName + "' scheduled for " + entry.NextRun.Format("2006-01-02 15:04"))
+		}
+		d.setRunning(imageName, true)
+		d.wg.Add(1)
+		go d.runScheduled(ctx, imageName, spec, imageSchedule)
+	}
+
+	if changed {
+		serr := d.table.Save()
+		if serr != nil {
+			log.Println("Could not save schedule table: " + serr.Error())
+		}
+	}
+}
+
+// runScheduled builds an image, then schedules its next run. Missed runs
+// are not repeated, the next run is counted from when the build ended.
+func (d *daemon) runScheduled(ctx context.Context, imageName string, spec string, imageSchedule schedule.Schedule) {
+	defer d.wg.Done()
+	defer func() { <-d.slots }()
+	defer d.setRunning(imageName, false)
+
+	started := time.Now()
+	result := buildOne(ctx, d.imageDir, imageName, true, d.options)
+
+	// A build stopped by shutting down is run again when the daemon restarts
+	if ctx.Err() != nil {
+		return
+	}
+	if result.err != nil {
+		log.Println("Scheduled build of '" + imageName + "' failed: " + result.err.Error())
+	}
+
+	next := imageSchedule.Next(time.Now())
+	d.table.Set(imageName, schedule.Entry{Spec: spec, LastRun: started, NextRun: next})
+	serr := d.table.Save()
+	if serr != nil {
+		log.Println("Could not save schedule table: " + serr.Error())
+	}
+	log.Println("Next run of '" + imageName + "' at " + next.Format("2006-01-02 15:04"))
+}
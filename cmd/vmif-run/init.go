@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/bocajspear1/vmifactory/internal/imagemanage"
+)
+
+// initCommand creates the directory tree for a new image
+func initCommand(imageDir string, args []string) int {
+	flags := flag.NewFlagSet("init", flag.ExitOnError)
+	var hypervisor = flags.String("hypervisor", "vbox", "Hypervisor of the source image, one of: "+strings.Join(imagemanage.GetBuilderNames(), ", "))
+	var importPath = flags.String("import", "", "Existing OVA or QCOW2 image to copy in as the source image")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: vmif-run init [options] <image-name>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	image, err := imagemanage.InitImage(imageDir, flags.Arg(0), *hypervisor, *importPath)
+	if err != nil {
+		fmt.Println("Could not create image: " + err.Error())
+		return 1
+	}
+
+	fmt.Println("Created image '" + image.ImageName + "' in " + image.ImageRootDir)
+	fmt.Println("Fill out " + image.GetConfigPath() + ", then add scripts to " + image.GetRunPath() + " and " + image.GetRunOncePath())
+	if *importPath == "" {
+		fmt.Println("Copy the source image to " + image.ImageRootDir + "/" + image.Config.Source.ImageFile)
+	}
+	return 0
+}
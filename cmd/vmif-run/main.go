@@ -1,28 +1,78 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	"github.com/bocajspear1/vmifactory/internal/imagemanage"
+	"github.com/bocajspear1/vmifactory/internal/packer"
+	"github.com/bocajspear1/vmifactory/internal/settings"
 )
 
-func runImageBuild(image *imagemanage.VMImage, testBuild bool, noCommit bool) error {
+// logProgress returns an event handler that logs a summary line for the
+// major events of a Packer build
+func logProgress(logger *log.Logger) func(packer.Event) {
+	return func(event packer.Event) {
+		switch event.Type {
+		case packer.EventBuilderStarted:
+			logger.Println("[progress] Builder " + event.Builder + " started")
+		case packer.EventProvisioner:
+			logger.Printf("[progress] Provisioning step %d of %d\n", event.Current, event.Total)
+		case packer.EventArtifact:
+			logger.Println("[progress] Artifact produced: " + event.Artifact)
+		}
+	}
+}
+
+// buildOptions are the command line options that change how images are built
+type buildOptions struct {
+	testBuild   bool
+	noCommit    bool
+	forceUnlock bool
+}
+
+func runImageBuild(ctx context.Context, image *imagemanage.VMImage, options buildOptions) error {
 	if !image.Exists() {
 		return errors.New("Image '" + image.ImageName + "' does not exist or is not properly configured")
 	}
-	image.PrepareBuild()
-	runerr := image.RunBuild(testBuild)
+	if options.forceUnlock {
+		uerr := image.ForceUnlock()
+		if uerr != nil {
+			return uerr
+		}
+	}
+	lock, lerr := image.Lock()
+	if lerr != nil {
+		return lerr
+	}
+	defer lock.Unlock()
+
+	// Configs from before the schema version are upgraded on their first build
+	if image.NeedsMigration() {
+		merr := image.MigrateForBuild()
+		if merr != nil {
+			return merr
+		}
+	}
+
+	preperr := image.PrepareBuild(ctx)
+	if preperr != nil {
+		return preperr
+	}
+	runerr := image.RunBuild(ctx, options.testBuild)
 	if runerr != nil {
 		return runerr
 	}
-	if !noCommit {
-		commiterr := image.CommitBuild()
+	if !options.noCommit {
+		commiterr := image.CommitBuild(ctx)
 		if commiterr != nil {
 			return commiterr
 		}
@@ -33,17 +83,54 @@ func runImageBuild(image *imagemanage.VMImage, testBuild bool, noCommit bool) er
 
 func main() {
 
-	const IMAGEDIR string = "./images"
-
 	var noCommit = flag.Bool("nocommit", false, "Do not commit the images to be used. Useful for testing the images before distribution.")
 	var testBuild = flag.Bool("test", false, "Don't actually do the build, useful for testing post-processing")
 	var listImages = flag.Bool("list", false, "List the known available images")
 	var runBuild = flag.String("run", "", "Set to run only one build instead of them all")
+	var forceUnlock = flag.Bool("force-unlock", false, "Remove the build lock files of the images to build, for when a build hangs while holding them")
+	var daemonMode = flag.Bool("daemon", false, "Keep running and rebuild images on their 'build'/'schedule'")
+	var jobs = flag.Int("jobs", 1, "Number of images to build at the same time")
+	var timeout = flag.Duration("timeout", 0, "Stop all builds after this long, such as '6h'. Images can also set their own 'build_timeout'")
 
-	var logFilePath = flag.String("logfile", "./vmif-run.log", "File to log to")
+	var configPath = flag.String("config", "", "Global config file, defaults to $"+settings.ConfigEnv+", ./vmifactory.json or /etc/vmifactory/vmifactory.json")
+	var logFilePath = flag.String("logfile", "", "File to log to, defaults to the 'log'/'run_file' setting")
 
 	flag.Parse()
 
+	// The daemon would remove locks of manual builds for as long as it runs
+	if *daemonMode && *forceUnlock {
+		fmt.Println("-force-unlock cannot be used with -daemon, force the unlock with -run first")
+		os.Exit(2)
+	}
+
+	globalSettings, serr := settings.Init(*configPath)
+	if serr != nil {
+		fmt.Println(serr)
+		os.Exit(2)
+	}
+	imageDir := globalSettings.ImageDir
+
+	// Subcommands have their own options
+	if flag.NArg() > 0 {
+		switch flag.Arg(0) {
+		case "init":
+			os.Exit(initCommand(imageDir, flag.Args()[1:]))
+		case "validate":
+			os.Exit(validateCommand(imageDir, flag.Args()[1:]))
+		case "migrate":
+			os.Exit(migrateCommand(imageDir, flag.Args()[1:]))
+		case "secret":
+			os.Exit(secretCommand(flag.Args()[1:]))
+		default:
+			fmt.Println("Unknown command '" + flag.Arg(0) + "'")
+			os.Exit(2)
+		}
+	}
+
+	if *logFilePath == "" {
+		*logFilePath = globalSettings.Log.RunFile
+	}
+
 	// Setup logging to Stdout and file
 	logFile, err := os.OpenFile(*logFilePath, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0660)
 	if err != nil {
@@ -53,40 +140,56 @@ func main() {
 	mw := io.MultiWriter(os.Stdout, logFile)
 	log.SetOutput(mw)
 
-	if *runBuild != "" {
-		filteredImage := strings.ReplaceAll(*runBuild, ".", "")
-		image, ierr := imagemanage.NewVMImage(IMAGEDIR, filteredImage)
-		if ierr != nil {
-			fmt.Println("Error finding image '" + filteredImage + "'")
-			fmt.Println(ierr)
-			return
-		}
-		fmt.Println("Running '" + image.Config.Name + "'")
-		berr := runImageBuild(image, *testBuild, *noCommit)
-		if berr != nil {
-			fmt.Println(ierr)
-			return
-		}
-		return
+	// Finish or roll back commits interrupted by a crash
+	imagemanage.RecoverCommits(imageDir)
+
+	// Stop builds cleanly on Ctrl-C or when the service is stopped
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
 	}
 
-	images := imagemanage.GetAvailableImages(IMAGEDIR)
+	options := buildOptions{
+		testBuild:   *testBuild,
+		noCommit:    *noCommit,
+		forceUnlock: *forceUnlock,
+	}
 
-	for _, imagePath := range images {
-		image, ierr := imagemanage.NewVMImage(IMAGEDIR, imagePath)
-		if ierr != nil {
-			fmt.Println(ierr)
-			return
-		}
-		if *listImages {
-			fmt.Println(image.ImageName + " - " + image.Config.Name)
-		} else {
-			runerr := runImageBuild(image, *testBuild, *noCommit)
-			if runerr != nil {
-				fmt.Println(ierr)
-				return
+	if *listImages {
+		for _, imagePath := range imagemanage.GetAvailableImages(imageDir) {
+			image, ierr := imagemanage.NewVMImage(imageDir, imagePath)
+			if ierr != nil {
+				fmt.Println(imagePath + " - Error: " + ierr.Error())
+				continue
 			}
+			fmt.Println(image.ImageName + " - " + image.Config.Name)
+		}
+		return
+	}
+
+	if *daemonMode {
+		derr := runDaemon(ctx, imageDir, *jobs, options)
+		if derr != nil {
+			log.Println(derr)
+			os.Exit(1)
 		}
+		return
 	}
 
+	var imageNames []string
+	if *runBuild != "" {
+		imageNames = []string{strings.ReplaceAll(*runBuild, ".", "")}
+	} else {
+		imageNames = imagemanage.GetAvailableImages(imageDir)
+	}
+
+	results := runBuilds(ctx, imageDir, imageNames, *jobs, options)
+	failed := logSummary(results)
+	if failed > 0 {
+		os.Exit(1)
+	}
 }
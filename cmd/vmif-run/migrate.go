@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/bocajspear1/vmifactory/internal/imagemanage"
+)
+
+// migrateCommand upgrades the configs of the given images, or all of them
+func migrateCommand(imageDir string, args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: vmif-run migrate [image-name...]")
+		fmt.Fprintln(flags.Output(), "Upgrades image configs to schema version "+strconv.Itoa(imagemanage.CurrentSchemaVersion)+", keeping the old config with a '.v<version>' suffix")
+	}
+	flags.Parse(args)
+
+	imageNames := flags.Args()
+	if len(imageNames) == 0 {
+		imageNames = imagemanage.GetAvailableImages(imageDir)
+	}
+
+	failed := 0
+	for _, imageName := range imageNames {
+		migrated, err := imagemanage.MigrateImage(imageDir, imageName)
+		if err != nil {
+			fmt.Println(imageName + ": could not migrate: " + err.Error())
+			failed++
+		} else if migrated {
+			fmt.Println(imageName + ": migrated")
+		} else {
+			fmt.Println(imageName + ": already current")
+		}
+	}
+
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
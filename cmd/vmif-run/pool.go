@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bocajspear1/vmifactory/internal/imagemanage"
+)
+
+// buildResult is the outcome of building one image
+type buildResult struct {
+	imageName string
+	err       error
+	duration  time.Duration
+}
+
+// runBuilds builds the images with up to jobs builds at once. A failed
+// build does not stop the others. Results are in the order of imageNames.
+func runBuilds(ctx context.Context, imageDir string, imageNames []string, jobs int, options buildOptions) []buildResult {
+	if jobs < 1 {
+		jobs = 1
+	}
+	results := make([]buildResult, len(imageNames))
+	queue := make(chan int)
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < jobs; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range queue {
+				results[index] = buildOne(ctx, imageDir, imageNames[index], jobs > 1, options)
+			}
+		}()
+	}
+
+	for index := range imageNames {
+		if ctx.Err() != nil {
+			results[index] = buildResult{imageName: imageNames[index], err: ctx.Err()}
+			continue
+		}
+		queue <- index
+	}
+	close(queue)
+	wg.Wait()
+
+	return results
+}
+
+func buildOne(ctx context.Context, imageDir string, imageName string, prefixLogs bool, options buildOptions) buildResult {
+	result := buildResult{imageName: imageName}
+	start := time.Now()
+
+	image, ierr := imagemanage.NewVMImage(imageDir, imageName)
+	if ierr != nil {
+		result.err = ierr
+		return result
+	}
+	// Parallel builds get their own prefix so their output can be told apart
+	logger := log.Default()
+	if prefixLogs {
+		logger = log.New(log.Writer(), "["+imageName+"] ", log.Flags())
+		image.Logger = logger
+	}
+	image.EventHandler = logProgress(logger)
+
+	logger.Println("Running '" + image.Config.Name + "'")
+
+	result.err = runImageBuild(ctx, image, options)
+	result.duration = time.Since(start).Round(time.Second)
+	if result.err != nil {
+		logger.Println("Build failed: " + result.err.Error())
+	}
+	return result
+}
+
+// logSummary logs the result of every build and returns the number that failed
+func logSummary(results []buildResult) int {
+	failed := 0
+	log.Println("Build summary:")
+	for _, result := range results {
+		if result.err != nil {
+			failed++
+			log.Println("  FAILED  " + result.imageName + " (" + result.duration.String() + "): " + result.err.Error())
+		} else {
+			log.Println("  OK      " + result.imageName + " (" + result.duration.String() + ")")
+		}
+	}
+	log.Printf("%d of %d builds succeeded\n", len(results)-failed, len(results))
+	return failed
+}
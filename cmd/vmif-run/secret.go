@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bocajspear1/vmifactory/internal/secrets"
+	"github.com/bocajspear1/vmifactory/internal/settings"
+)
+
+// secretCommand manages the encrypted keystore for 'keystore:' secrets
+func secretCommand(args []string) int {
+	flags := flag.NewFlagSet("secret", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: vmif-run secret <command>")
+		fmt.Fprintln(flags.Output(), "  init           Create the keystore key")
+		fmt.Fprintln(flags.Output(), "  set <name>     Store a secret, read from the first line of standard input")
+		fmt.Fprintln(flags.Output(), "  remove <name>  Remove a secret")
+		fmt.Fprintln(flags.Output(), "  list           List the secret names")
+		fmt.Fprintln(flags.Output(), "Refer to a secret in an image config as 'keystore:<name>'")
+	}
+	flags.Parse(args)
+
+	keystorePath := settings.Get().KeystorePath
+	keyPath := settings.Get().KeystoreKeyFile
+
+	if flags.NArg() == 1 && flags.Arg(0) == "init" {
+		kerr := secrets.GenerateKey(keyPath)
+		if kerr != nil {
+			fmt.Println(kerr)
+			return 1
+		}
+		fmt.Println("Created keystore key " + keyPath + ", keep a copy somewhere safe")
+		return 0
+	}
+
+	if flags.NArg() == 0 || (flags.Arg(0) == "list") != (flags.NArg() == 1) || flags.NArg() > 2 {
+		flags.Usage()
+		return 2
+	}
+
+	keystore, oerr := secrets.OpenKeystore(keystorePath, keyPath)
+	if oerr != nil {
+		fmt.Println(oerr)
+		return 1
+	}
+
+	switch flags.Arg(0) {
+	case "list":
+		for _, name := range keystore.Names() {
+			fmt.Println(name)
+		}
+		return 0
+	case "set":
+		reader := bufio.NewReader(os.Stdin)
+		secret, rerr := reader.ReadString('\n')
+		if rerr != nil && secret == "" {
+			fmt.Println("Could not read the secret from standard input")
+			return 1
+		}
+		secret = strings.TrimSuffix(strings.TrimSuffix(secret, "\n"), "\r")
+		serr := keystore.Set(flags.Arg(1), secret)
+		if serr != nil {
+			fmt.Println(serr)
+			return 1
+		}
+	case "remove":
+		if !keystore.Remove(flags.Arg(1)) {
+			fmt.Println("Secret '" + flags.Arg(1) + "' is not in the keystore")
+			return 1
+		}
+	default:
+		flags.Usage()
+		return 2
+	}
+
+	werr := keystore.Save()
+	if werr != nil {
+		fmt.Println(werr)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/bocajspear1/vmifactory/internal/imagemanage"
+)
+
+// validateCommand checks the configs of the given images, or all of them
+func validateCommand(imageDir string, args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	var printSchema = flags.Bool("schema", false, "Print the JSON Schema for image configs, for use in editors")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: vmif-run validate [options] [image-name...]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *printSchema {
+		fmt.Println(string(imagemanage.ConfigSchema()))
+		return 0
+	}
+
+	imageNames := flags.Args()
+	if len(imageNames) == 0 {
+		imageNames = imagemanage.GetAvailableImages(imageDir)
+	}
+
+	problemCount := 0
+	for _, imageName := range imageNames {
+		problems := imagemanage.ValidateImage(imageDir, imageName)
+		for _, problem := range problems {
+			fmt.Println(problem.Error())
+		}
+		if len(problems) == 0 {
+			fmt.Println(imageName + ": OK")
+		}
+		problemCount += len(problems)
+	}
+
+	if problemCount > 0 {
+		fmt.Printf("Found %d problem(s)\n", problemCount)
+		return 1
+	}
+	return 0
+}
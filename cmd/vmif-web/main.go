@@ -1,17 +1,22 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/bocajspear1/vmifactory/internal/imagemanage"
+	"github.com/bocajspear1/vmifactory/internal/secrets"
+	"github.com/bocajspear1/vmifactory/internal/settings"
 )
 
 func getFileContentType(filePath string) (string, error) {
@@ -47,7 +52,7 @@ func getHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	imagePathName := sections[0]
 	imageName := sections[1]
-	image, ierr := imagemanage.NewVMImage("./images", imagePathName)
+	image, ierr := imagemanage.NewVMImage(settings.Get().ImageDir, imagePathName)
 	if ierr != nil {
 		fmt.Fprintln(w, "Invalid image file name requested")
 		return
@@ -86,10 +91,203 @@ func getHandler(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// vagrantProviders are the Vagrant box outputs and their providers
+var vagrantProviders = []struct {
+	Key      string
+	Provider string
+}{
+	{"vagrant_virtualbox", "virtualbox"},
+	{"vagrant_libvirt", "libvirt"},
+}
+
+// vagrantProvider is a box file of a version in a Vagrant catalog
+type vagrantProvider struct {
+	Name         string `json:"name"`
+	URL          string `json:"url"`
+	ChecksumType string `json:"checksum_type"`
+	Checksum     string `json:"checksum"`
+}
+
+// vagrantVersion is a version of a box in a Vagrant catalog
+type vagrantVersion struct {
+	Version   string            `json:"version"`
+	Providers []vagrantProvider `json:"providers"`
+}
+
+// vagrantCatalog is the metadata Vagrant reads to add and update a box
+type vagrantCatalog struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	Versions    []vagrantVersion `json:"versions"`
+}
+
+// vagrantBoxVersion returns the box version of a build date, like
+// "20260102.150405" for "2026-01-02 15:04:05", empty if it is invalid
+func vagrantBoxVersion(date string) string {
+	buildTime, terr := time.Parse("2006-01-02 15:04:05", date)
+	if terr != nil {
+		return ""
+	}
+	return buildTime.Format("20060102.150405")
+}
+
+// baseURL returns the address users reach the server at
+func baseURL(r *http.Request) string {
+	if settings.Get().Publish.BaseURL != "" {
+		return strings.TrimSuffix(settings.Get().Publish.BaseURL, "/")
+	}
+	if r.TLS != nil {
+		return "https://" + r.Host
+	}
+	return "http://" + r.Host
+}
+
+// Handles the Vagrant catalog of an image, at /vagrant/<image>.json
+func vagrantHandler(w http.ResponseWriter, r *http.Request) {
+	imagePathName := strings.TrimSuffix(r.URL.Path[len("/vagrant/"):], ".json")
+	if !imagemanage.SafeImageDirName(imagePathName) {
+		http.Error(w, "Invalid image requested", http.StatusNotFound)
+		return
+	}
+	image, ierr := imagemanage.NewVMImage(settings.Get().ImageDir, imagePathName)
+	if ierr != nil {
+		http.Error(w, "Invalid image requested", http.StatusNotFound)
+		return
+	}
+	if image.CommitFlagExists() {
+		http.Error(w, "This image is being updated, please try again later", http.StatusServiceUnavailable)
+		return
+	}
+
+	// The builds of each provider are grouped by their date, the outputs
+	// of a build share it
+	outputs := image.GetOutputs()
+	versions := make(map[string][]vagrantProvider)
+	for _, provider := range vagrantProviders {
+		fileName, ok := outputs[provider.Key]
+		if !ok {
+			continue
+		}
+		outputState := image.State.Outputs[provider.Key]
+		builds := [][3]string{
+			{outputState.CurrentDate, outputState.CurrentHash, fileName},
+			{outputState.LastDate, outputState.LastHash, "Old-" + fileName},
+		}
+		for _, build := range builds {
+			version := vagrantBoxVersion(build[0])
+			if version == "" || build[1] == "" {
+				continue
+			}
+			versions[version] = append(versions[version], vagrantProvider{
+				Name:         provider.Provider,
+				URL:          baseURL(r) + "/get/" + imagePathName + "/" + build[2],
+				ChecksumType: "sha256",
+				Checksum:     build[1],
+			})
+		}
+	}
+	if len(versions) == 0 {
+		http.Error(w, "The image has no Vagrant boxes", http.StatusNotFound)
+		return
+	}
+
+	catalog := vagrantCatalog{
+		Name:        imagePathName,
+		Description: image.Config.Description,
+		Versions:    make([]vagrantVersion, 0, len(versions)),
+	}
+	for version, providers := range versions {
+		catalog.Versions = append(catalog.Versions, vagrantVersion{Version: version, Providers: providers})
+	}
+	// Newest first, the versions all have the same length
+	sort.Slice(catalog.Versions, func(i, j int) bool {
+		return catalog.Versions[i].Version > catalog.Versions[j].Version
+	})
+
+	log.Println("Vagrant catalog of " + imagePathName)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(catalog)
+}
+
+// outputView is an output file of an image on the index page
+type outputView struct {
+	Title string
+	File  string
+	imagemanage.OutputState
+	// Password and LastPassword are the guest passwords of the current and Old- files
+	Password     string
+	LastPassword string
+}
+
+// imageView is an image on the index page
+type imageView struct {
+	PathName   string
+	InProgress bool
+	Config     *imagemanage.BuilderConfig
+	Outputs    []outputView
+	// Password and SudoPassword are the login passwords if they are not secret references
+	Password     string
+	SudoPassword string
+	// Hardware describes the virtual machine, empty if it is not known
+	Hardware string
+	// HasVagrant is set if the image has a Vagrant catalog
+	HasVagrant bool
+}
+
+// hardwareDescription describes a hardware summary for the page, like
+// "2 vCPUs, 2048 MB RAM, disks 20 GB + 1 GB, 1 NIC, BIOS"
+func hardwareDescription(hardware *imagemanage.HardwareSummary) string {
+	if hardware == nil {
+		return ""
+	}
+	plural := func(count int, name string) string {
+		if count == 1 {
+			return "1 " + name
+		}
+		return strconv.Itoa(count) + " " + name + "s"
+	}
+	diskSizes := make([]string, len(hardware.DiskSizes))
+	for i, size := range hardware.DiskSizes {
+		diskSizes[i] = strings.TrimSuffix(strconv.FormatFloat(float64(size)/(1<<30), 'f', 1, 64), ".0") + " GB"
+	}
+	parts := []string{
+		plural(hardware.CPUs, "vCPU"),
+		strconv.FormatInt(hardware.MemoryMB, 10) + " MB RAM",
+	}
+	if len(diskSizes) == 1 {
+		parts = append(parts, "disk "+diskSizes[0])
+	} else if len(diskSizes) > 1 {
+		parts = append(parts, "disks "+strings.Join(diskSizes, " + "))
+	}
+	parts = append(parts, plural(hardware.NICs, "NIC"), strings.ToUpper(hardware.Firmware))
+	return strings.Join(parts, ", ")
+}
+
+// publicPassword returns a password for the page, hiding secret references
+func publicPassword(value string) string {
+	if secrets.IsReference(value) {
+		return "(ask the administrator)"
+	}
+	return value
+}
+
+// outputTitles are the page headings of the outputs, in page order
+var outputTitles = []struct {
+	Key   string
+	Title string
+}{
+	{"vbox", "VirtualBox"},
+	{"kvm", "KVM/QEMU"},
+	{"hyperv", "Hyper-V"},
+	{"vmware", "VMWare"},
+	{"vagrant_virtualbox", "Vagrant (VirtualBox)"},
+	{"vagrant_libvirt", "Vagrant (libvirt)"},
+}
+
 // Handles the index page
 func mainHandler(w http.ResponseWriter, r *http.Request) {
 
-	pageTemplate, err := template.ParseFiles("./web/templates/template.html")
+	pageTemplate, err := template.ParseFiles(settings.Get().Publish.TemplatesDir + "/template.html")
 	if err != nil {
 		fmt.Fprintf(w, "Template failed")
 		return
@@ -97,21 +295,55 @@ func mainHandler(w http.ResponseWriter, r *http.Request) {
 	log.Println("Accessed index")
 
 	// Prepare image data for the template
-	images := imagemanage.GetAvailableImages("./images")
+	images := imagemanage.GetAvailableImages(settings.Get().ImageDir)
 
-	imageDataList := make([]imagemanage.BuilderConfig, 0)
+	imageDataList := make([]imageView, 0)
 
 	for _, imagePath := range images {
-		image, ierr := imagemanage.NewVMImage("./images", imagePath)
+		image, ierr := imagemanage.NewVMImage(settings.Get().ImageDir, imagePath)
 		if ierr == nil {
-			image.Config.Metadata["image_path_name"] = imagePath
-			if image.CommitFlagExists() {
-				image.Config.Metadata["in_progress"] = "yes"
-			} else {
-				image.Config.Metadata["in_progress"] = ""
+			view := imageView{
+				PathName:   imagePath,
+				InProgress: image.CommitFlagExists(),
+				Config:     image.Config,
+
+				Password:     publicPassword(image.Config.Login.Password),
+				SudoPassword: publicPassword(image.Config.Login.SudoPassword),
+				Hardware:     hardwareDescription(image.State.Hardware),
+			}
+			if image.Config.Login.Rotate {
+				view.Password = "(changes every build, see each image file)"
+				view.SudoPassword = view.Password
+			}
+			outputs := image.GetOutputs()
+			for _, provider := range vagrantProviders {
+				if _, ok := outputs[provider.Key]; ok {
+					view.HasVagrant = true
+				}
+			}
+			for _, output := range outputTitles {
+				fileName, ok := outputs[output.Key]
+				if ok {
+					outputState := image.State.Outputs[output.Key]
+					outView := outputView{
+						Title:        output.Title,
+						File:         fileName,
+						OutputState:  outputState,
+						Password:     outputState.CurrentPassword,
+						LastPassword: outputState.LastPassword,
+					}
+					// Builds before a rotation have the config's password
+					if outView.Password == "" {
+						outView.Password = publicPassword(image.Config.Login.Password)
+					}
+					if outView.LastPassword == "" {
+						outView.LastPassword = publicPassword(image.Config.Login.Password)
+					}
+					view.Outputs = append(view.Outputs, outView)
+				}
 			}
 
-			imageDataList = append(imageDataList, *(image.Config))
+			imageDataList = append(imageDataList, view)
 		}
 	}
 
@@ -122,11 +354,24 @@ func mainHandler(w http.ResponseWriter, r *http.Request) {
 func main() {
 
 	// Parse options
-	var listenAt = flag.String("listen", ":8080", "Address:port to listen at")
-	var logFilePath = flag.String("logfile", "./vmif-web.log", "File to log to")
+	var configPath = flag.String("config", "", "Global config file, defaults to $"+settings.ConfigEnv+", ./vmifactory.json or /etc/vmifactory/vmifactory.json")
+	var listenAt = flag.String("listen", "", "Address:port to listen at, defaults to the 'publish'/'listen' setting")
+	var logFilePath = flag.String("logfile", "", "File to log to, defaults to the 'log'/'web_file' setting")
 
 	flag.Parse()
 
+	globalSettings, serr := settings.Init(*configPath)
+	if serr != nil {
+		fmt.Println(serr)
+		os.Exit(2)
+	}
+	if *listenAt == "" {
+		*listenAt = globalSettings.Publish.Listen
+	}
+	if *logFilePath == "" {
+		*logFilePath = globalSettings.Log.WebFile
+	}
+
 	// Setup logging to Stdout and file
 	logFile, err := os.OpenFile(*logFilePath, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0660)
 	if err != nil {
@@ -136,10 +381,14 @@ func main() {
 	mw := io.MultiWriter(os.Stdout, logFile)
 	log.SetOutput(mw)
 
+	// Finish or roll back commits interrupted by a crash
+	imagemanage.RecoverCommits(globalSettings.ImageDir)
+
 	// Setup the web server
-	fs := http.FileServer(http.Dir("web/static/"))
+	fs := http.FileServer(http.Dir(globalSettings.Publish.StaticDir))
 	http.Handle("/static/", http.StripPrefix("/static/", fs))
 	http.HandleFunc("/get/", getHandler)
+	http.HandleFunc("/vagrant/", vagrantHandler)
 	http.HandleFunc("/", mainHandler)
 
 	// Start the web server
@@ -1,12 +1,69 @@
 package converters
 
-import "os/exec"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os/exec"
 
-func DiskToQCOW2(initPath string, newPath string) (string, error) {
-	cmd := exec.Command("qemu-img", "convert", "-O", "qcow2", initPath, newPath)
+	"github.com/bocajspear1/vmifactory/internal/settings"
+)
+
+func DiskToQCOW2(ctx context.Context, initPath string, newPath string) (string, error) {
+	cmd := exec.CommandContext(ctx, settings.Get().QemuImgPath, "convert", "-O", "qcow2", initPath, newPath)
 	convertOut, err := cmd.Output()
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
 	if err != nil {
 		return "", err
 	}
 	return string(convertOut), nil
 }
+
+// DiskToVHDX converts a disk to a dynamically sized Hyper-V VHDX
+func DiskToVHDX(ctx context.Context, initPath string, newPath string) (string, error) {
+	cmd := exec.CommandContext(ctx, settings.Get().QemuImgPath, "convert", "-O", "vhdx", "-o", "subformat=dynamic", initPath, newPath)
+	convertOut, err := cmd.Output()
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(convertOut), nil
+}
+
+// DiskToVMDK converts a disk to a VMware VMDK of the given subformat,
+// like monolithicSparse or streamOptimized
+func DiskToVMDK(ctx context.Context, initPath string, newPath string, subformat string) (string, error) {
+	cmd := exec.CommandContext(ctx, settings.Get().QemuImgPath, "convert", "-O", "vmdk", "-o", "subformat="+subformat, initPath, newPath)
+	convertOut, err := cmd.Output()
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(convertOut), nil
+}
+
+// DiskVirtualSize returns the size of a disk as seen by the guest, in bytes
+func DiskVirtualSize(ctx context.Context, diskPath string) (int64, error) {
+	cmd := exec.CommandContext(ctx, settings.Get().QemuImgPath, "info", "--output=json", diskPath)
+	infoOut, err := cmd.Output()
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+	if err != nil {
+		return 0, err
+	}
+	var info struct {
+		VirtualSize int64 `json:"virtual-size"`
+	}
+	jerr := json.Unmarshal(infoOut, &info)
+	if jerr != nil {
+		return 0, errors.New("Could not read qemu-img info of " + diskPath + ": " + jerr.Error())
+	}
+	return info.VirtualSize, nil
+}
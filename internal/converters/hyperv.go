@@ -0,0 +1,112 @@
+package converters
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bocajspear1/vmifactory/internal/helpers"
+)
+
+const hypervDir = "hyperv-disks"
+
+// HyperVNoteName is the name of the import note packed with the VHDX disks
+const HyperVNoteName = "IMPORT-HYPERV.txt"
+
+// hypervNote returns the import instructions for the disks, the first
+// being the boot disk. firmware is FirmwareBIOS, FirmwareEFI or empty if
+// it is not known. Windows users get Windows line endings.
+func hypervNote(vmName string, firmware string, diskNames []string) string {
+	lines := []string{
+		vmName + " for Hyper-V",
+		"",
+		"The disks are dynamically sized VHDX files:",
+	}
+	for i, diskName := range diskNames {
+		if i == 0 {
+			lines = append(lines, "    "+diskName+" (boot disk)")
+		} else {
+			lines = append(lines, "    "+diskName)
+		}
+	}
+
+	generation := "1"
+	lines = append(lines, "", "To import the image:",
+		"1. Extract this zip to where the virtual machine's files should be kept.",
+		"2. In Hyper-V Manager, choose New > Virtual Machine.")
+	switch firmware {
+	case FirmwareBIOS:
+		lines = append(lines,
+			"3. Choose Generation 1, the image boots with BIOS.",
+			"   The boot disk is attached to IDE controller 0.")
+	case FirmwareEFI:
+		generation = "2"
+		lines = append(lines,
+			"3. Choose Generation 2, the image boots with UEFI. For Linux guests, turn Secure Boot",
+			"   off or set its template to 'Microsoft UEFI Certificate Authority'.")
+	default:
+		lines = append(lines,
+			"3. Choose the generation. The firmware of the image is not known, try Generation 1",
+			"   first, which most VirtualBox and KVM images need:",
+			"   - Generation 1 if the image boots with BIOS. The boot disk is attached to IDE controller 0.",
+			"   - Generation 2 if the image boots with UEFI. For Linux guests, turn Secure Boot off",
+			"     or set its template to 'Microsoft UEFI Certificate Authority'.")
+	}
+	lines = append(lines,
+		"4. At 'Connect Virtual Hard Disk', choose 'Use an existing virtual hard disk' and select "+diskNames[0]+".",
+		"5. Add any other disks to the virtual machine's SCSI controller.",
+		"",
+		"Or from PowerShell, in the extracted directory:",
+		"    New-VM -Name '"+vmName+"' -Generation "+generation+" -MemoryStartupBytes 2GB -VHDPath (Resolve-Path '"+diskNames[0]+"')",
+	)
+	if firmware == FirmwareEFI {
+		lines = append(lines, "    Set-VMFirmware -VMName '"+vmName+"' -EnableSecureBoot Off")
+	} else if firmware == "" {
+		lines = append(lines, "Use -Generation 2 instead if the image boots with UEFI.")
+	}
+	lines = append(lines, "")
+	return strings.Join(lines, "\r\n")
+}
+
+// DisksToHyperV converts the disks to VHDX, the first being the boot
+// disk, and zips them with an import note at outputPath. firmware picks
+// the generation in the note, empty if it is not known.
+func DisksToHyperV(ctx context.Context, logger *log.Logger, workDir string, vmName string, firmware string, diskList []string, outputPath string) error {
+	disksDir := workDir + "/" + hypervDir
+	os.Mkdir(disksDir, 0777)
+
+	convertedList := make([]string, len(diskList))
+	diskNames := make([]string, len(diskList))
+	logger.Println("(HyperV) Converting disks...")
+	for i, diskFile := range diskList {
+		// Disks are named after the VM, the order is kept for multiple disks
+		if len(diskList) == 1 {
+			diskNames[i] = vmName + ".vhdx"
+		} else {
+			diskNames[i] = vmName + "-disk" + strconv.Itoa(i+1) + ".vhdx"
+		}
+		convertedList[i] = disksDir + "/" + diskNames[i]
+		output, cerr := DiskToVHDX(ctx, diskFile, convertedList[i])
+		if cerr != nil {
+			return cerr
+		}
+		logger.Printf("%s", output)
+	}
+
+	notePath := disksDir + "/" + HyperVNoteName
+	werr := ioutil.WriteFile(notePath, []byte(hypervNote(vmName, firmware, diskNames)), 0644)
+	if werr != nil {
+		return werr
+	}
+
+	logger.Println("(HyperV) Building Zip...")
+	return helpers.ZipFiles(append(convertedList, notePath), outputPath)
+}
+
+// HyperVCleanup cleans up conversion artifacts
+func HyperVCleanup(workDir string) {
+	os.RemoveAll(workDir + "/" + hypervDir)
+}
@@ -0,0 +1,34 @@
+package converters
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHypervNote(t *testing.T) {
+	tests := []struct {
+		firmware string
+		want     []string
+		notWant  []string
+	}{
+		{FirmwareBIOS, []string{"-Generation 1 ", "Choose Generation 1"}, []string{"-Generation 2", "not known"}},
+		{FirmwareEFI, []string{"-Generation 2 ", "Choose Generation 2", "-EnableSecureBoot Off"}, []string{"-Generation 1", "not known"}},
+		{"", []string{"-Generation 1 ", "not known", "Use -Generation 2 instead"}, []string{"-EnableSecureBoot"}},
+	}
+	for _, test := range tests {
+		note := hypervNote("test", test.firmware, []string{"test-disk1.vhdx", "test-disk2.vhdx"})
+		for _, want := range test.want {
+			if !strings.Contains(note, want) {
+				t.Errorf("hypervNote(%q) does not have %q:\n%s", test.firmware, want, note)
+			}
+		}
+		for _, notWant := range test.notWant {
+			if strings.Contains(note, notWant) {
+				t.Errorf("hypervNote(%q) has %q:\n%s", test.firmware, notWant, note)
+			}
+		}
+		if strings.Count(note, "\n") != strings.Count(note, "\r\n") {
+			t.Errorf("hypervNote(%q) does not use Windows line endings", test.firmware)
+		}
+	}
+}
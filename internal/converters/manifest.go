@@ -0,0 +1,115 @@
+package converters
+
+import (
+	"archive/tar"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// manifestLineRegex matches manifest lines like "SHA256(disk.vmdk)= 0a1b..."
+var manifestLineRegex = regexp.MustCompile(`^(SHA1|SHA256)\s*\((.+)\)\s*=\s*([0-9a-fA-F]+)$`)
+
+// manifestDigest is a file digest from an OVA manifest
+type manifestDigest struct {
+	Algorithm string
+	Digest    string
+}
+
+// parseManifest parses an OVA's .mf manifest into the digest of each file
+func parseManifest(data []byte) (map[string]manifestDigest, error) {
+	digests := make(map[string]manifestDigest)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		match := manifestLineRegex.FindStringSubmatch(line)
+		if match == nil {
+			return nil, errors.New("Invalid OVA manifest line '" + line + "', only SHA1 and SHA256 digests are supported")
+		}
+		digests[match[2]] = manifestDigest{Algorithm: match[1], Digest: strings.ToLower(match[3])}
+	}
+	return digests, nil
+}
+
+// safeEntryName checks that an OVA entry is a plain file name. OVA files
+// are flat, so anything with a directory could write outside the
+// extraction directory.
+func safeEntryName(name string) bool {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+		return false
+	}
+	for _, c := range name {
+		if c < ' ' {
+			return false
+		}
+	}
+	return true
+}
+
+// checkEntry rejects OVA entries that are not regular files with safe names
+func checkEntry(header *tar.Header) error {
+	if !safeEntryName(header.Name) {
+		return errors.New("OVA entry '" + header.Name + "' is not a plain file name")
+	}
+	if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+		return errors.New("OVA entry '" + header.Name + "' is not a regular file")
+	}
+	return nil
+}
+
+// entryHasher computes both digests a manifest may use, since the
+// manifest can come after the files it describes
+type entryHasher struct {
+	sha1   hash.Hash
+	sha256 hash.Hash
+}
+
+func newEntryHasher() *entryHasher {
+	return &entryHasher{sha1: sha1.New(), sha256: sha256.New()}
+}
+
+// Writer returns the writer feeding both digests
+func (h *entryHasher) Writer() io.Writer {
+	return io.MultiWriter(h.sha1, h.sha256)
+}
+
+// Digest returns the hex digest for a manifest algorithm
+func (h *entryHasher) Digest(algorithm string) string {
+	if algorithm == "SHA1" {
+		return hex.EncodeToString(h.sha1.Sum(nil))
+	}
+	return hex.EncodeToString(h.sha256.Sum(nil))
+}
+
+// verifyManifest checks every file of the OVA against the manifest
+// digests, and that the manifest lists no files the OVA does not have
+func verifyManifest(digests map[string]manifestDigest, hashers map[string]*entryHasher) error {
+	names := make([]string, 0, len(hashers))
+	for name := range hashers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		expected, ok := digests[name]
+		if !ok {
+			return errors.New("OVA file '" + name + "' is not in the manifest")
+		}
+		if hashers[name].Digest(expected.Algorithm) != expected.Digest {
+			return errors.New("OVA file '" + name + "' does not match its " + expected.Algorithm + " digest in the manifest")
+		}
+	}
+	for name := range digests {
+		if _, ok := hashers[name]; !ok {
+			return errors.New("OVA manifest lists '" + name + "', which is not in the OVA")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package converters
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSafeEntryName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"disk001.vmdk", true},
+		{"test.ovf", true},
+		{"..disk", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"../disk.vmdk", false},
+		{"/etc/passwd", false},
+		{"dir/disk.vmdk", false},
+		{`..\disk.vmdk`, false},
+		{"disk\n.vmdk", false},
+	}
+	for _, test := range tests {
+		got := safeEntryName(test.name)
+		if got != test.want {
+			t.Errorf("safeEntryName(%q) = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestParseManifest(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string]manifestDigest
+		wantErr bool
+	}{
+		{
+			name: "both algorithms",
+			data: "SHA256 (test.ovf) = 0A1B\r\nSHA1(disk.vmdk)= ff00\n\n",
+			want: map[string]manifestDigest{
+				"test.ovf":  {Algorithm: "SHA256", Digest: "0a1b"},
+				"disk.vmdk": {Algorithm: "SHA1", Digest: "ff00"},
+			},
+		},
+		{
+			name: "empty",
+			data: "",
+			want: map[string]manifestDigest{},
+		},
+		{
+			name:    "unsupported algorithm",
+			data:    "MD5(disk.vmdk)= ff00\n",
+			wantErr: true,
+		},
+		{
+			name:    "not a digest",
+			data:    "SHA256(disk.vmdk)= not-hex\n",
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		got, err := parseManifest([]byte(test.data))
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: parseManifest() succeeded, want an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: parseManifest() error = %v", test.name, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: parseManifest() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
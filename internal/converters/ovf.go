@@ -0,0 +1,310 @@
+package converters
+
+import (
+	"encoding/xml"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CIM resource types of OVF hardware items
+const (
+	ovfResourceCPU      = 3
+	ovfResourceMemory   = 4
+	ovfResourceIDE      = 5
+	ovfResourceSCSI     = 6
+	ovfResourceEthernet = 10
+	ovfResourceDisk     = 17
+	ovfResourceSATA     = 20
+)
+
+// Disk controller types
+const (
+	ControllerIDE  = "ide"
+	ControllerSATA = "sata"
+	ControllerSCSI = "scsi"
+)
+
+// Firmware types
+const (
+	FirmwareBIOS = "bios"
+	FirmwareEFI  = "efi"
+)
+
+// OVFEnvelope is an OVF descriptor. Elements are matched by their local
+// name, so OVF 1.0 and 2.0 and the exporters' namespaces all work.
+type OVFEnvelope struct {
+	References    []OVFFile        `xml:"References>File"`
+	Disks         []OVFDisk        `xml:"DiskSection>Disk"`
+	Networks      []OVFNetwork     `xml:"NetworkSection>Network"`
+	VirtualSystem OVFVirtualSystem `xml:"VirtualSystem"`
+}
+
+// OVFFile is a file of the package
+type OVFFile struct {
+	ID   string `xml:"id,attr"`
+	Href string `xml:"href,attr"`
+	Size int64  `xml:"size,attr"`
+}
+
+// OVFDisk is a virtual disk, stored in a file of the package
+type OVFDisk struct {
+	DiskID   string `xml:"diskId,attr"`
+	FileRef  string `xml:"fileRef,attr"`
+	Capacity int64  `xml:"capacity,attr"`
+	// CapacityAllocationUnits is like "byte * 2^30", bytes if empty
+	CapacityAllocationUnits string `xml:"capacityAllocationUnits,attr"`
+	Format                  string `xml:"format,attr"`
+}
+
+// OVFNetwork is a logical network the adapters connect to
+type OVFNetwork struct {
+	Name string `xml:"name,attr"`
+}
+
+// OVFVirtualSystem is the virtual machine of the package
+type OVFVirtualSystem struct {
+	ID     string    `xml:"id,attr"`
+	Name   string    `xml:"Name"`
+	OSType string    `xml:"OperatingSystemSection>OSType"`
+	Items  []OVFItem `xml:"VirtualHardwareSection>Item"`
+	// OVF 2.0 has its own elements for disks and network adapters
+	StorageItems  []OVFItem `xml:"VirtualHardwareSection>StorageItem"`
+	EthernetItems []OVFItem `xml:"VirtualHardwareSection>EthernetPortItem"`
+	// Configs are VMware's extra settings, like the firmware
+	Configs []OVFConfig `xml:"VirtualHardwareSection>Config"`
+	// VBoxFirmware is the firmware in VirtualBox's machine section
+	VBoxFirmware OVFVBoxFirmware `xml:"Machine>Hardware>Firmware"`
+}
+
+// OVFItem is a virtual hardware item, a CIM_ResourceAllocationSettingData
+type OVFItem struct {
+	InstanceID      string `xml:"InstanceID"`
+	ResourceType    int    `xml:"ResourceType"`
+	ResourceSubType string `xml:"ResourceSubType"`
+	VirtualQuantity int64  `xml:"VirtualQuantity"`
+	AllocationUnits string `xml:"AllocationUnits"`
+	Address         string `xml:"Address"`
+	AddressOnParent string `xml:"AddressOnParent"`
+	Parent          string `xml:"Parent"`
+	HostResource    string `xml:"HostResource"`
+	Connection      string `xml:"Connection"`
+}
+
+// OVFConfig is a VMware key and value setting
+type OVFConfig struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// OVFVBoxFirmware is VirtualBox's firmware setting
+type OVFVBoxFirmware struct {
+	Type string `xml:"type,attr"`
+}
+
+// hardwareItems returns the hardware items of both OVF versions
+func (s OVFVirtualSystem) hardwareItems() []OVFItem {
+	items := make([]OVFItem, 0, len(s.Items)+len(s.StorageItems)+len(s.EthernetItems))
+	items = append(items, s.Items...)
+	items = append(items, s.StorageItems...)
+	return append(items, s.EthernetItems...)
+}
+
+// OVFDiskInfo is a disk attached to the virtual machine
+type OVFDiskInfo struct {
+	// File is the disk's file name in the package
+	File string
+	// CapacityBytes is the virtual size of the disk
+	CapacityBytes int64
+	// Controller is the controller type, ControllerIDE, ControllerSATA or ControllerSCSI
+	Controller string
+	// ControllerSubType is the controller model, like "AHCI" or "lsilogic"
+	ControllerSubType string
+	// Port is the disk's place on the controller
+	Port int
+}
+
+// OVFHardware is the virtual hardware of an OVF descriptor
+type OVFHardware struct {
+	Name string
+	// OSType is VirtualBox's OS type, like "Debian_64", empty for other exporters
+	OSType   string
+	CPUs     int
+	MemoryMB int64
+	// NICTypes are the network adapter types, like "E1000", in order
+	NICTypes []string
+	// Firmware is FirmwareBIOS or FirmwareEFI
+	Firmware string
+	// Disks are in boot order, by controller then port
+	Disks []OVFDiskInfo
+}
+
+// BootDisk returns the first disk
+func (h OVFHardware) BootDisk() (OVFDiskInfo, error) {
+	if len(h.Disks) == 0 {
+		return OVFDiskInfo{}, errors.New("Hardware has no disks to boot from")
+	}
+	return h.Disks[0], nil
+}
+
+// DiskFiles returns the file names of the disks in boot order
+func (h OVFHardware) DiskFiles() []string {
+	files := make([]string, len(h.Disks))
+	for i, disk := range h.Disks {
+		files[i] = disk.File
+	}
+	return files
+}
+
+// unitBytes returns the number of bytes in an OVF allocation unit like
+// "byte * 2^20" or an older name like "MegaBytes". Empty is defaultUnit.
+func unitBytes(units string, defaultUnit int64) int64 {
+	units = strings.ToLower(strings.ReplaceAll(units, " ", ""))
+	switch units {
+	case "":
+		return defaultUnit
+	case "byte", "bytes":
+		return 1
+	case "byte*2^10", "kilobytes", "kb":
+		return 1 << 10
+	case "byte*2^20", "megabytes", "mb":
+		return 1 << 20
+	case "byte*2^30", "gigabytes", "gb":
+		return 1 << 30
+	case "byte*2^40", "terabytes", "tb":
+		return 1 << 40
+	}
+	return defaultUnit
+}
+
+// controllerType returns the controller type of a resource type, empty if it is not a disk controller
+func controllerType(resourceType int) string {
+	switch resourceType {
+	case ovfResourceIDE:
+		return ControllerIDE
+	case ovfResourceSATA:
+		return ControllerSATA
+	case ovfResourceSCSI:
+		return ControllerSCSI
+	}
+	return ""
+}
+
+// ParseOVF parses an OVF descriptor
+func ParseOVF(data []byte) (*OVFEnvelope, error) {
+	envelope := new(OVFEnvelope)
+	xerr := xml.Unmarshal(data, envelope)
+	if xerr != nil {
+		return nil, errors.New("Could not parse OVF descriptor: " + xerr.Error())
+	}
+	return envelope, nil
+}
+
+// Hardware returns the virtual hardware of the descriptor, with the
+// disks in boot order
+func (e OVFEnvelope) Hardware() (*OVFHardware, error) {
+	system := e.VirtualSystem
+	hardware := &OVFHardware{
+		Name:     system.ID,
+		OSType:   strings.TrimSpace(system.OSType),
+		CPUs:     1,
+		NICTypes: make([]string, 0),
+		Firmware: FirmwareBIOS,
+		Disks:    make([]OVFDiskInfo, 0),
+	}
+	if strings.EqualFold(system.VBoxFirmware.Type, "EFI") {
+		hardware.Firmware = FirmwareEFI
+	}
+	for _, config := range system.Configs {
+		if config.Key == "firmware" && strings.EqualFold(config.Value, "efi") {
+			hardware.Firmware = FirmwareEFI
+		}
+	}
+
+	files := make(map[string]string)
+	for _, file := range e.References {
+		files[file.ID] = file.Href
+	}
+	disks := make(map[string]OVFDisk)
+	for _, disk := range e.Disks {
+		disks[disk.DiskID] = disk
+	}
+
+	items := system.hardwareItems()
+	// Controllers are ordered by where they are in the descriptor
+	controllerOrder := make(map[string]int)
+	controllers := make(map[string]OVFItem)
+	for i, item := range items {
+		if controllerType(item.ResourceType) != "" {
+			controllers[item.InstanceID] = item
+			controllerOrder[item.InstanceID] = i
+		}
+	}
+
+	// controllerOrders has the order of each disk's controller, by its
+	// index in hardware.Disks
+	controllerOrders := make([]int, 0)
+	for _, item := range items {
+		switch item.ResourceType {
+		case ovfResourceCPU:
+			hardware.CPUs = int(item.VirtualQuantity)
+		case ovfResourceMemory:
+			hardware.MemoryMB = item.VirtualQuantity * unitBytes(item.AllocationUnits, 1<<20) / (1 << 20)
+		case ovfResourceEthernet:
+			hardware.NICTypes = append(hardware.NICTypes, item.ResourceSubType)
+		case ovfResourceDisk:
+			controller, ok := controllers[item.Parent]
+			if !ok {
+				return nil, errors.New("OVF disk item " + item.InstanceID + " is not attached to a disk controller")
+			}
+			// HostResource is like "ovf:/disk/vmdisk1"
+			diskID := item.HostResource[strings.LastIndex(item.HostResource, "/")+1:]
+			disk, ok := disks[diskID]
+			if !ok {
+				return nil, errors.New("OVF disk item " + item.InstanceID + " refers to unknown disk '" + item.HostResource + "'")
+			}
+			fileName, ok := files[disk.FileRef]
+			if !ok {
+				return nil, errors.New("OVF disk '" + diskID + "' refers to unknown file '" + disk.FileRef + "'")
+			}
+			port, perr := strconv.Atoi(strings.TrimSpace(item.AddressOnParent))
+			if perr != nil || port < 0 {
+				return nil, errors.New("OVF disk item " + item.InstanceID + " has invalid AddressOnParent '" + item.AddressOnParent + "'")
+			}
+			hardware.Disks = append(hardware.Disks, OVFDiskInfo{
+				File:              fileName,
+				CapacityBytes:     disk.Capacity * unitBytes(disk.CapacityAllocationUnits, 1),
+				Controller:        controllerType(controller.ResourceType),
+				ControllerSubType: controller.ResourceSubType,
+				Port:              port,
+			})
+			controllerOrders = append(controllerOrders, controllerOrder[item.Parent])
+		}
+	}
+
+	if hardware.MemoryMB <= 0 {
+		return nil, errors.New("OVF descriptor has no memory size")
+	}
+	if len(hardware.Disks) == 0 {
+		return nil, errors.New("OVF descriptor has no disk attached to a controller")
+	}
+	// Sort the indexes, so disks sharing a file keep their own order
+	order := make([]int, len(hardware.Disks))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		first, second := order[i], order[j]
+		if controllerOrders[first] != controllerOrders[second] {
+			return controllerOrders[first] < controllerOrders[second]
+		}
+		return hardware.Disks[first].Port < hardware.Disks[second].Port
+	})
+	sorted := make([]OVFDiskInfo, len(order))
+	for i, index := range order {
+		sorted[i] = hardware.Disks[index]
+	}
+	hardware.Disks = sorted
+	return hardware, nil
+}
@@ -0,0 +1,123 @@
+package converters
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// ovfWithDisks returns an OVF descriptor with three disk files and
+// the hardware items
+func ovfWithDisks(items string) string {
+	return `<?xml version="1.0"?>
+<Envelope ovf:version="1.0" xmlns="http://schemas.dmtf.org/ovf/envelope/1" xmlns:ovf="http://schemas.dmtf.org/ovf/envelope/1" xmlns:rasd="http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_ResourceAllocationSettingData">
+  <References>
+    <File ovf:id="file1" ovf:href="a.vmdk"/>
+    <File ovf:id="file2" ovf:href="b.vmdk"/>
+    <File ovf:id="file3" ovf:href="c.vmdk"/>
+  </References>
+  <DiskSection>
+    <Disk ovf:capacity="1" ovf:capacityAllocationUnits="byte * 2^30" ovf:diskId="disk1" ovf:fileRef="file1"/>
+    <Disk ovf:capacity="2" ovf:capacityAllocationUnits="byte * 2^30" ovf:diskId="disk2" ovf:fileRef="file2"/>
+    <Disk ovf:capacity="3" ovf:capacityAllocationUnits="byte * 2^30" ovf:diskId="disk3" ovf:fileRef="file3"/>
+    <Disk ovf:capacity="4" ovf:capacityAllocationUnits="byte * 2^30" ovf:diskId="shared" ovf:fileRef="file1"/>
+  </DiskSection>
+  <VirtualSystem ovf:id="test">
+    <VirtualHardwareSection>
+      <Item><rasd:AllocationUnits>MegaBytes</rasd:AllocationUnits><rasd:InstanceID>1</rasd:InstanceID><rasd:ResourceType>4</rasd:ResourceType><rasd:VirtualQuantity>1024</rasd:VirtualQuantity></Item>
+      <Item><rasd:Address>0</rasd:Address><rasd:InstanceID>2</rasd:InstanceID><rasd:ResourceSubType>PIIX4</rasd:ResourceSubType><rasd:ResourceType>5</rasd:ResourceType></Item>
+      <Item><rasd:Address>0</rasd:Address><rasd:InstanceID>3</rasd:InstanceID><rasd:ResourceSubType>AHCI</rasd:ResourceSubType><rasd:ResourceType>20</rasd:ResourceType></Item>
+      ` + items + `
+    </VirtualHardwareSection>
+  </VirtualSystem>
+</Envelope>
+`
+}
+
+// diskItem returns a disk item on the controller with the instance ID parent
+func diskItem(id string, parent string, address string, disk string) string {
+	return `<Item><rasd:AddressOnParent>` + address + `</rasd:AddressOnParent><rasd:HostResource>/disk/` + disk +
+		`</rasd:HostResource><rasd:InstanceID>` + id + `</rasd:InstanceID><rasd:Parent>` + parent + `</rasd:Parent><rasd:ResourceType>17</rasd:ResourceType></Item>`
+}
+
+func TestHardwareDisks(t *testing.T) {
+	tests := []struct {
+		name  string
+		items string
+		// want is the file and capacity in GB of each disk in boot order
+		want    []string
+		wantErr string
+	}{
+		{
+			name:  "controller then port order",
+			items: diskItem("10", "3", "1", "disk3") + diskItem("11", "3", "0", "disk2") + diskItem("12", "2", "1", "disk1"),
+			want:  []string{"a.vmdk 1", "b.vmdk 2", "c.vmdk 3"},
+		},
+		{
+			name:  "disks sharing a file",
+			items: diskItem("10", "3", "0", "shared") + diskItem("11", "2", "0", "disk1") + diskItem("12", "3", "1", "disk2"),
+			want:  []string{"a.vmdk 1", "a.vmdk 4", "b.vmdk 2"},
+		},
+		{
+			name:    "empty address",
+			items:   diskItem("10", "3", "0", "disk1") + diskItem("11", "3", "", "disk2"),
+			wantErr: "OVF disk item 11 has invalid AddressOnParent ''",
+		},
+		{
+			name:    "bad address",
+			items:   diskItem("10", "3", "first", "disk1"),
+			wantErr: "OVF disk item 10 has invalid AddressOnParent 'first'",
+		},
+		{
+			name:    "negative address",
+			items:   diskItem("10", "3", "-1", "disk1"),
+			wantErr: "invalid AddressOnParent '-1'",
+		},
+		{
+			name:    "no controller",
+			items:   diskItem("10", "9", "0", "disk1"),
+			wantErr: "is not attached to a disk controller",
+		},
+		{
+			name:    "no disks",
+			items:   "",
+			wantErr: "has no disk attached to a controller",
+		},
+	}
+	for _, test := range tests {
+		envelope, perr := ParseOVF([]byte(ovfWithDisks(test.items)))
+		if perr != nil {
+			t.Fatalf("%s: ParseOVF() error = %v", test.name, perr)
+		}
+		hardware, herr := envelope.Hardware()
+		if test.wantErr != "" {
+			if herr == nil || !strings.Contains(herr.Error(), test.wantErr) {
+				t.Errorf("%s: Hardware() error = %v, want it to contain %q", test.name, herr, test.wantErr)
+			}
+			continue
+		}
+		if herr != nil {
+			t.Errorf("%s: Hardware() error = %v", test.name, herr)
+			continue
+		}
+		got := make([]string, len(hardware.Disks))
+		for i, disk := range hardware.Disks {
+			got[i] = disk.File + " " + strconv.FormatInt(disk.CapacityBytes>>30, 10)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: Hardware() disks = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestBootDisk(t *testing.T) {
+	_, berr := OVFHardware{}.BootDisk()
+	if berr == nil {
+		t.Error("BootDisk() without disks succeeded")
+	}
+	disk, berr := OVFHardware{Disks: []OVFDiskInfo{{File: "a.vmdk"}, {File: "b.vmdk"}}}.BootDisk()
+	if berr != nil || disk.File != "a.vmdk" {
+		t.Errorf("BootDisk() = %+v, %v, want a.vmdk", disk, berr)
+	}
+}
@@ -1,6 +1,7 @@
 package converters
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
@@ -11,6 +12,8 @@ import (
 	"net/url"
 	"strings"
 	"time"
+
+	"github.com/bocajspear1/vmifactory/internal/settings"
 )
 
 type ProxmoxConfig struct {
@@ -54,13 +57,13 @@ func loadConfig(path string) (*ProxmoxConfig, error) {
 	return &config, nil
 }
 
-func doPost(url string, values url.Values, authToken string, csrfToken string) ([]byte, error) {
+func doPost(ctx context.Context, url string, values url.Values, authToken string, csrfToken string) ([]byte, error) {
 
 	netClient := &http.Client{
 		Timeout: time.Second * 10,
 	}
 
-	req, rerr := http.NewRequest(http.MethodPost, url, strings.NewReader(values.Encode()))
+	req, rerr := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(values.Encode()))
 	if rerr != nil {
 		return nil, rerr
 	}
@@ -95,9 +98,9 @@ func doPost(url string, values url.Values, authToken string, csrfToken string) (
 	return body, nil
 }
 
-func ProxmoxRunVBoxConverter(targetName string, sourceFiles []string) error {
+func ProxmoxRunVBoxConverter(ctx context.Context, targetName string, sourceFiles []string) error {
 
-	config, err := loadConfig("./config/proxmox.json")
+	config, err := loadConfig(settings.Get().ProxmoxConfig)
 	if err != nil {
 		return err
 	}
@@ -114,7 +117,7 @@ func ProxmoxRunVBoxConverter(targetName string, sourceFiles []string) error {
 	vals.Add("username", config.Username)
 	vals.Add("password", config.Password)
 
-	authResp, rerr := doPost(urlBase+"access/ticket", vals, "", "")
+	authResp, rerr := doPost(ctx, urlBase+"access/ticket", vals, "", "")
 	if rerr != nil {
 		return rerr
 	}
@@ -135,7 +138,7 @@ func ProxmoxRunVBoxConverter(targetName string, sourceFiles []string) error {
 	// execVals.Add("cmd", "sleep 30")
 	// execVals.Add("node", config.Node)
 
-	execResp, rerr := doPost(urlBase+"nodes/"+config.Node+"/vncshell", execVals, authToken, crsfToken)
+	execResp, rerr := doPost(ctx, urlBase+"nodes/"+config.Node+"/vncshell", execVals, authToken, crsfToken)
 	if rerr != nil {
 		return rerr
 	}
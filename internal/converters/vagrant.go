@@ -0,0 +1,182 @@
+package converters
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bocajspear1/vmifactory/internal/helpers"
+)
+
+const vagrantDir = "vagrant-box"
+
+// Vagrant providers
+const (
+	VagrantVirtualBox = "virtualbox"
+	VagrantLibvirt    = "libvirt"
+)
+
+// VagrantGuest is how Vagrant logs in to the box
+type VagrantGuest struct {
+	Username string
+	// Password is empty when it cannot be shipped in the box, then the
+	// user's Vagrantfile has to say how to log in
+	Password string
+	// Windows guests are logged in to with WinRM
+	Windows bool
+}
+
+// rubyQuote quotes a Ruby string
+func rubyQuote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, `#`, `\#`).Replace(value) + `"`
+}
+
+// vagrantfile returns the Vagrantfile packed in a box, which is the
+// default for every machine made from it
+func vagrantfile(provider string, guest VagrantGuest) string {
+	lines := []string{`Vagrant.configure("2") do |config|`}
+	if guest.Windows {
+		lines = append(lines,
+			`  config.vm.guest = :windows`,
+			`  config.vm.communicator = "winrm"`,
+			`  config.winrm.username = `+rubyQuote(guest.Username),
+		)
+		if guest.Password != "" {
+			lines = append(lines, `  config.winrm.password = `+rubyQuote(guest.Password))
+		} else {
+			lines = append(lines, `  # Set config.winrm.password in your Vagrantfile`)
+		}
+	} else {
+		lines = append(lines, `  config.ssh.username = `+rubyQuote(guest.Username))
+		if guest.Password != "" {
+			lines = append(lines, `  config.ssh.password = `+rubyQuote(guest.Password))
+		} else {
+			lines = append(lines, `  # Log in with Vagrant's insecure key in the box, or set`,
+				`  # config.ssh.private_key_path in your Vagrantfile`)
+		}
+	}
+	if provider == VagrantLibvirt {
+		lines = append(lines,
+			`  config.vm.provider :libvirt do |libvirt|`,
+			`    libvirt.driver = "kvm"`,
+			`  end`,
+		)
+	}
+	lines = append(lines, `end`, ``)
+	return strings.Join(lines, "\n")
+}
+
+// writeVagrantFiles writes the metadata.json and Vagrantfile of a box
+// into the directory, returning their paths
+func writeVagrantFiles(boxDir string, metadata map[string]interface{}, provider string, guest VagrantGuest) ([]string, error) {
+	metadataJSON, merr := json.MarshalIndent(metadata, "", "    ")
+	if merr != nil {
+		return nil, merr
+	}
+	metadataPath := boxDir + "/metadata.json"
+	werr := ioutil.WriteFile(metadataPath, metadataJSON, 0644)
+	if werr != nil {
+		return nil, werr
+	}
+	vagrantfilePath := boxDir + "/Vagrantfile"
+	werr = ioutil.WriteFile(vagrantfilePath, []byte(vagrantfile(provider, guest)), 0644)
+	if werr != nil {
+		return nil, werr
+	}
+	return []string{metadataPath, vagrantfilePath}, nil
+}
+
+// VBoxToVagrant packs the OVF descriptor and disks extracted by
+// VBoxExtractDisks into a VirtualBox Vagrant box at outputPath
+func VBoxToVagrant(ctx context.Context, logger *log.Logger, workDir string, guest VagrantGuest, diskList []string, outputPath string) error {
+	boxDir := workDir + "/" + vagrantDir
+	os.RemoveAll(boxDir)
+	os.Mkdir(boxDir, 0777)
+
+	logger.Println("(Vagrant) Building VirtualBox box...")
+	descriptors, gerr := filepath.Glob(workDir + "/" + ovaDir + "/*.ovf")
+	if gerr != nil {
+		return gerr
+	}
+	if len(descriptors) != 1 {
+		return errors.New("Could not find the extracted OVF descriptor for the Vagrant box")
+	}
+	// Vagrant imports the box from box.ovf
+	ovfPath := boxDir + "/box.ovf"
+	cerr := helpers.CopyFile(descriptors[0], ovfPath)
+	if cerr != nil {
+		return cerr
+	}
+
+	metadata := map[string]interface{}{"provider": VagrantVirtualBox}
+	boxFiles, werr := writeVagrantFiles(boxDir, metadata, VagrantVirtualBox, guest)
+	if werr != nil {
+		return werr
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	packFiles := append([]string{ovfPath}, diskList...)
+	return helpers.TarAndGzipFiles(append(packFiles, boxFiles...), outputPath)
+}
+
+// DisksToVagrantLibvirt converts the disks to QCOW2, the first being the
+// boot disk, and packs them into a libvirt Vagrant box at outputPath. A
+// single disk is box.img, which every vagrant-libvirt version reads,
+// multiple disks use the newer disks list.
+func DisksToVagrantLibvirt(ctx context.Context, logger *log.Logger, workDir string, guest VagrantGuest, diskList []string, outputPath string) error {
+	boxDir := workDir + "/" + vagrantDir
+	os.RemoveAll(boxDir)
+	os.Mkdir(boxDir, 0777)
+
+	convertedList := make([]string, len(diskList))
+	logger.Println("(Vagrant) Converting disks for libvirt box...")
+	for i, diskFile := range diskList {
+		if len(diskList) == 1 {
+			convertedList[i] = boxDir + "/box.img"
+		} else {
+			convertedList[i] = boxDir + "/box_" + strconv.Itoa(i+1) + ".img"
+		}
+		output, cerr := DiskToQCOW2(ctx, diskFile, convertedList[i])
+		if cerr != nil {
+			return cerr
+		}
+		logger.Printf("%s", output)
+	}
+
+	metadata := map[string]interface{}{"provider": VagrantLibvirt}
+	if len(convertedList) == 1 {
+		virtualSize, serr := DiskVirtualSize(ctx, convertedList[0])
+		if serr != nil {
+			return serr
+		}
+		// The size is in whole GB, rounded up so the disk fits
+		metadata["format"] = "qcow2"
+		metadata["virtual_size"] = (virtualSize + (1 << 30) - 1) / (1 << 30)
+	} else {
+		disks := make([]map[string]string, len(convertedList))
+		for i, diskPath := range convertedList {
+			disks[i] = map[string]string{"path": filepath.Base(diskPath), "format": "qcow2"}
+		}
+		metadata["disks"] = disks
+	}
+	boxFiles, werr := writeVagrantFiles(boxDir, metadata, VagrantLibvirt, guest)
+	if werr != nil {
+		return werr
+	}
+
+	logger.Println("(Vagrant) Building libvirt box...")
+	return helpers.TarAndGzipFiles(append(convertedList, boxFiles...), outputPath)
+}
+
+// VagrantCleanup cleans up conversion artifacts
+func VagrantCleanup(workDir string) {
+	os.RemoveAll(workDir + "/" + vagrantDir)
+}
@@ -0,0 +1,58 @@
+package converters
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVagrantfile(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider string
+		guest    VagrantGuest
+		want     []string
+		notWant  []string
+	}{
+		{
+			name:     "password",
+			provider: VagrantVirtualBox,
+			guest:    VagrantGuest{Username: "vagrant", Password: `pa"ss#{x}`},
+			want:     []string{`config.ssh.username = "vagrant"`, `config.ssh.password = "pa\"ss\#{x}"`},
+			notWant:  []string{"winrm", "libvirt", "private_key_path"},
+		},
+		{
+			name:     "no password",
+			provider: VagrantLibvirt,
+			guest:    VagrantGuest{Username: "vagrant"},
+			want:     []string{`config.ssh.username = "vagrant"`, "# config.ssh.private_key_path", `libvirt.driver = "kvm"`},
+			notWant:  []string{"config.ssh.password"},
+		},
+		{
+			name:     "windows password",
+			provider: VagrantVirtualBox,
+			guest:    VagrantGuest{Username: "admin", Password: "secret", Windows: true},
+			want:     []string{`config.vm.communicator = "winrm"`, `config.winrm.username = "admin"`, `config.winrm.password = "secret"`},
+			notWant:  []string{"config.ssh"},
+		},
+		{
+			name:     "windows no password",
+			provider: VagrantVirtualBox,
+			guest:    VagrantGuest{Username: "admin", Windows: true},
+			want:     []string{`config.winrm.username = "admin"`, "# Set config.winrm.password"},
+			notWant:  []string{"config.winrm.password ="},
+		},
+	}
+	for _, test := range tests {
+		got := vagrantfile(test.provider, test.guest)
+		for _, want := range test.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: vagrantfile() does not have %q:\n%s", test.name, want, got)
+			}
+		}
+		for _, notWant := range test.notWant {
+			if strings.Contains(got, notWant) {
+				t.Errorf("%s: vagrantfile() has %q:\n%s", test.name, notWant, got)
+			}
+		}
+	}
+}
@@ -2,8 +2,10 @@ package converters
 
 import (
 	"archive/tar"
-	"fmt"
+	"context"
+	"errors"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"strings"
@@ -13,58 +15,154 @@ import (
 
 const ovaDir = "ova-disks"
 
-// VBoxExtractDisks extracts the disks the produced OVA
-// into the directory workDir + /ova-disks
-func VBoxExtractDisks(workDir string, ovaPath string) ([]string, error) {
+// VBoxExtractDisks extracts the disks of the produced OVA into the
+// directory workDir + /ova-disks, along with its OVF descriptor. The
+// disks are returned in boot order with the hardware the descriptor describes.
+// Every file of the OVA is checked against its manifest. Without
+// requireManifest, an OVA with no manifest is only warned about, for
+// OVAs that were not produced by Packer.
+func VBoxExtractDisks(ctx context.Context, logger *log.Logger, workDir string, ovaPath string, requireManifest bool) ([]string, *OVFHardware, error) {
 
-	ovaDisks := make([]string, 0)
-
-	// Copy our OVA
-	tarFile := workDir + "/convert.tar"
-	copyerr := helpers.CopyFile(ovaPath, tarFile)
-
-	if copyerr != nil {
-		return nil, copyerr
-	}
-
-	reader, err := os.Open(tarFile)
-	defer reader.Close()
+	reader, err := os.Open(ovaPath)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	defer reader.Close()
 
 	disksDir := workDir + "/" + ovaDir
 
-	// Extract the disk(s)
+	// Extract the disk(s), streaming from the OVA
 	tarReader := tar.NewReader(reader)
 	os.Mkdir(disksDir, 0777)
 
+	var hardware *OVFHardware
+	var digests map[string]manifestDigest
+	hashers := make(map[string]*entryHasher)
+	seen := make(map[string]bool)
+	extracted := make(map[string]bool)
 	for {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
 		tarHeader, err := tarReader.Next()
 		if err == io.EOF {
 			break // End of archive
 		} else if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+
+		cerr := checkEntry(tarHeader)
+		if cerr != nil {
+			return nil, nil, cerr
+		}
+		// A later copy of a file could replace a verified one
+		if seen[tarHeader.Name] {
+			return nil, nil, errors.New("OVA entry '" + tarHeader.Name + "' appears more than once")
 		}
+		seen[tarHeader.Name] = true
 
-		if strings.Contains(tarHeader.Name, ".vmdk") {
+		// The OVF descriptor comes first and says which files are disks
+		if hardware == nil {
+			if !strings.HasSuffix(tarHeader.Name, ".ovf") {
+				return nil, nil, errors.New("OVA " + ovaPath + " does not start with its OVF descriptor")
+			}
+			hasher := newEntryHasher()
+			descriptor, rerr := ioutil.ReadAll(io.TeeReader(tarReader, hasher.Writer()))
+			if rerr != nil {
+				return nil, nil, rerr
+			}
+			hashers[tarHeader.Name] = hasher
+			werr := ioutil.WriteFile(disksDir+"/"+tarHeader.Name, descriptor, 0644)
+			if werr != nil {
+				return nil, nil, werr
+			}
+			envelope, perr := ParseOVF(descriptor)
+			if perr != nil {
+				return nil, nil, perr
+			}
+			hardware, perr = envelope.Hardware()
+			if perr != nil {
+				return nil, nil, perr
+			}
+			for _, diskFile := range hardware.DiskFiles() {
+				if !safeEntryName(diskFile) {
+					return nil, nil, errors.New("OVF disk file '" + diskFile + "' is not a plain file name")
+				}
+			}
+			continue
+		}
+
+		// The manifest and certificate are not in the manifest
+		if strings.HasSuffix(tarHeader.Name, ".mf") {
+			manifest, rerr := ioutil.ReadAll(tarReader)
+			if rerr != nil {
+				return nil, nil, rerr
+			}
+			var perr error
+			digests, perr = parseManifest(manifest)
+			if perr != nil {
+				return nil, nil, perr
+			}
+			continue
+		} else if strings.HasSuffix(tarHeader.Name, ".cert") {
+			continue
+		}
+
+		hasher := newEntryHasher()
+		hashers[tarHeader.Name] = hasher
+		if stringInList(tarHeader.Name, hardware.DiskFiles()) {
 			outPath := disksDir + "/" + tarHeader.Name
 			destination, cerr := os.Create(outPath)
 			if cerr != nil {
-				return nil, cerr
+				return nil, nil, cerr
 			}
-			_, werr := io.Copy(destination, tarReader)
+			_, werr := io.Copy(io.MultiWriter(destination, hasher.Writer()), tarReader)
+			destination.Close()
 			if werr != nil {
-				return nil, werr
+				return nil, nil, werr
+			}
+			extracted[tarHeader.Name] = true
+		} else {
+			_, rerr := io.Copy(hasher.Writer(), tarReader)
+			if rerr != nil {
+				return nil, nil, rerr
 			}
-			ovaDisks = append(ovaDisks, outPath)
 		}
 	}
 
-	log.Println("VBox TAR cleanup...")
-	os.Remove(tarFile)
+	if hardware == nil {
+		return nil, nil, errors.New("OVA " + ovaPath + " is empty")
+	}
+	if digests != nil {
+		logger.Println("(VBox) Verifying OVA manifest...")
+		verr := verifyManifest(digests, hashers)
+		if verr != nil {
+			return nil, nil, verr
+		}
+	} else if requireManifest {
+		return nil, nil, errors.New("OVA " + ovaPath + " has no manifest to verify it with")
+	} else {
+		logger.Println("(VBox) Warning: OVA " + ovaPath + " has no manifest, its files are not verified")
+	}
+
+	ovaDisks := make([]string, 0, len(hardware.Disks))
+	for _, diskFile := range hardware.DiskFiles() {
+		if !extracted[diskFile] {
+			return nil, nil, errors.New("OVA " + ovaPath + " is missing disk " + diskFile)
+		}
+		ovaDisks = append(ovaDisks, disksDir+"/"+diskFile)
+	}
 
-	return ovaDisks, nil
+	return ovaDisks, hardware, nil
+}
+
+func stringInList(value string, list []string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
 }
 
 // VBoxCleanup cleans up conversion artifacts
@@ -73,21 +171,21 @@ func VBoxCleanup(workDir string) {
 	os.RemoveAll(disksDir)
 }
 
-func VBoxToKVM(diskList []string, outputPath string) error {
+func VBoxToKVM(ctx context.Context, logger *log.Logger, diskList []string, outputPath string) error {
 	convertedList := make([]string, len(diskList))
-	log.Println("(KVM) Converting disks...")
+	logger.Println("(KVM) Converting disks...")
 	// For each disk, make a QCOW2 copy
 	for i, diskFile := range diskList {
 		newName := strings.ReplaceAll(diskFile, ".vmdk", ".qcow2")
 		convertedList[i] = newName
-		output, cerr := DiskToQCOW2(diskFile, newName)
+		output, cerr := DiskToQCOW2(ctx, diskFile, newName)
 		if cerr != nil {
 			return cerr
 		}
-		fmt.Printf("%s", output)
+		logger.Printf("%s", output)
 
 	}
-	log.Println("(KVM) Building Gzipped Tar...")
+	logger.Println("(KVM) Building Gzipped Tar...")
 	// Tar and gzip the QCOW2 files
 	packErr := helpers.TarAndGzipFiles(convertedList, outputPath)
 	if packErr != nil {
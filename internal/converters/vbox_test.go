@@ -0,0 +1,271 @@
+package converters
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+// testOVF returns an OVF descriptor with a single disk in diskFile
+func testOVF(diskFile string) string {
+	return `<?xml version="1.0"?>
+<Envelope ovf:version="1.0" xmlns="http://schemas.dmtf.org/ovf/envelope/1" xmlns:ovf="http://schemas.dmtf.org/ovf/envelope/1" xmlns:rasd="http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_ResourceAllocationSettingData" xmlns:vbox="http://www.virtualbox.org/ovf/machine">
+  <References>
+    <File ovf:id="file1" ovf:href="` + diskFile + `"/>
+  </References>
+  <DiskSection>
+    <Disk ovf:capacity="10737418240" ovf:diskId="vmdisk1" ovf:fileRef="file1"/>
+  </DiskSection>
+  <VirtualSystem ovf:id="test">
+    <OperatingSystemSection ovf:id="96">
+      <vbox:OSType ovf:required="false">Debian_64</vbox:OSType>
+    </OperatingSystemSection>
+    <VirtualHardwareSection>
+      <Item><rasd:InstanceID>1</rasd:InstanceID><rasd:ResourceType>3</rasd:ResourceType><rasd:VirtualQuantity>1</rasd:VirtualQuantity></Item>
+      <Item><rasd:AllocationUnits>MegaBytes</rasd:AllocationUnits><rasd:InstanceID>2</rasd:InstanceID><rasd:ResourceType>4</rasd:ResourceType><rasd:VirtualQuantity>1024</rasd:VirtualQuantity></Item>
+      <Item><rasd:Address>0</rasd:Address><rasd:InstanceID>3</rasd:InstanceID><rasd:ResourceSubType>AHCI</rasd:ResourceSubType><rasd:ResourceType>20</rasd:ResourceType></Item>
+      <Item><rasd:AddressOnParent>0</rasd:AddressOnParent><rasd:HostResource>/disk/vmdisk1</rasd:HostResource><rasd:InstanceID>4</rasd:InstanceID><rasd:Parent>3</rasd:Parent><rasd:ResourceType>17</rasd:ResourceType></Item>
+    </VirtualHardwareSection>
+  </VirtualSystem>
+</Envelope>
+`
+}
+
+// ovaEntry is a file of a test OVA
+type ovaEntry struct {
+	Name     string
+	Body     string
+	Typeflag byte
+	Linkname string
+}
+
+// digestLine returns the manifest line of a file
+func digestLine(algorithm string, name string, body string) string {
+	if algorithm == "SHA1" {
+		sum := sha1.Sum([]byte(body))
+		return "SHA1(" + name + ")= " + hex.EncodeToString(sum[:]) + "\n"
+	}
+	sum := sha256.Sum256([]byte(body))
+	return "SHA256(" + name + ")= " + hex.EncodeToString(sum[:]) + "\n"
+}
+
+// writeTestOVA writes the entries as an OVA in the directory
+func writeTestOVA(t *testing.T, dir string, entries []ovaEntry) string {
+	t.Helper()
+	ovaPath := dir + "/test.ova"
+	file, cerr := os.Create(ovaPath)
+	if cerr != nil {
+		t.Fatal(cerr)
+	}
+	defer file.Close()
+	tarWriter := tar.NewWriter(file)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.Name, Mode: 0644, Typeflag: entry.Typeflag, Linkname: entry.Linkname}
+		if entry.Typeflag == tar.TypeReg {
+			header.Size = int64(len(entry.Body))
+		}
+		werr := tarWriter.WriteHeader(header)
+		if werr != nil {
+			t.Fatal(werr)
+		}
+		_, werr = tarWriter.Write([]byte(entry.Body))
+		if werr != nil {
+			t.Fatal(werr)
+		}
+	}
+	cerr = tarWriter.Close()
+	if cerr != nil {
+		t.Fatal(cerr)
+	}
+	return ovaPath
+}
+
+func TestVBoxExtractDisks(t *testing.T) {
+	ovf := testOVF("test-disk001.vmdk")
+	disk := "disk data"
+	ovfEntry := ovaEntry{Name: "test.ovf", Body: ovf, Typeflag: tar.TypeReg}
+	diskEntry := ovaEntry{Name: "test-disk001.vmdk", Body: disk, Typeflag: tar.TypeReg}
+	manifest := digestLine("SHA256", "test.ovf", ovf) + digestLine("SHA256", "test-disk001.vmdk", disk)
+	mfEntry := ovaEntry{Name: "test.mf", Body: manifest, Typeflag: tar.TypeReg}
+
+	tests := []struct {
+		name    string
+		entries []ovaEntry
+		// source OVAs may have no manifest
+		source bool
+		// wantErr is part of the error, empty if extracting succeeds
+		wantErr string
+	}{
+		{
+			name:    "valid",
+			entries: []ovaEntry{ovfEntry, diskEntry, mfEntry},
+		},
+		{
+			name:    "manifest first after the descriptor",
+			entries: []ovaEntry{ovfEntry, mfEntry, diskEntry},
+		},
+		{
+			name: "SHA1 manifest and certificate",
+			entries: []ovaEntry{ovfEntry, diskEntry,
+				{Name: "test.mf", Body: digestLine("SHA1", "test.ovf", ovf) + digestLine("SHA1", "test-disk001.vmdk", disk), Typeflag: tar.TypeReg},
+				{Name: "test.cert", Body: "certificate", Typeflag: tar.TypeReg},
+			},
+		},
+		{
+			name:    "parent directory entry",
+			entries: []ovaEntry{ovfEntry, {Name: "../test-disk001.vmdk", Body: disk, Typeflag: tar.TypeReg}, mfEntry},
+			wantErr: "'../test-disk001.vmdk' is not a plain file name",
+		},
+		{
+			name:    "absolute path entry",
+			entries: []ovaEntry{ovfEntry, {Name: "/tmp/test-disk001.vmdk", Body: disk, Typeflag: tar.TypeReg}, mfEntry},
+			wantErr: "'/tmp/test-disk001.vmdk' is not a plain file name",
+		},
+		{
+			name:    "subdirectory entry",
+			entries: []ovaEntry{ovfEntry, {Name: "disks/test-disk001.vmdk", Body: disk, Typeflag: tar.TypeReg}, mfEntry},
+			wantErr: "is not a plain file name",
+		},
+		{
+			name:    "symlink entry",
+			entries: []ovaEntry{ovfEntry, {Name: "test-disk001.vmdk", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}, mfEntry},
+			wantErr: "'test-disk001.vmdk' is not a regular file",
+		},
+		{
+			name:    "hard link entry",
+			entries: []ovaEntry{ovfEntry, {Name: "test-disk001.vmdk", Typeflag: tar.TypeLink, Linkname: "test.ovf"}, mfEntry},
+			wantErr: "'test-disk001.vmdk' is not a regular file",
+		},
+		{
+			name:    "directory entry",
+			entries: []ovaEntry{ovfEntry, {Name: "disks", Typeflag: tar.TypeDir}, diskEntry, mfEntry},
+			wantErr: "'disks' is not a regular file",
+		},
+		{
+			name:    "unsafe disk in the descriptor",
+			entries: []ovaEntry{{Name: "test.ovf", Body: testOVF("../test-disk001.vmdk"), Typeflag: tar.TypeReg}, diskEntry, mfEntry},
+			wantErr: "OVF disk file '../test-disk001.vmdk' is not a plain file name",
+		},
+		{
+			name:    "duplicate entry",
+			entries: []ovaEntry{ovfEntry, diskEntry, {Name: "test-disk001.vmdk", Body: "replaced", Typeflag: tar.TypeReg}, mfEntry},
+			wantErr: "'test-disk001.vmdk' appears more than once",
+		},
+		{
+			name:    "descriptor not first",
+			entries: []ovaEntry{diskEntry, ovfEntry, mfEntry},
+			wantErr: "does not start with its OVF descriptor",
+		},
+		{
+			name:    "empty",
+			entries: []ovaEntry{},
+			wantErr: "is empty",
+		},
+		{
+			name:    "missing manifest",
+			entries: []ovaEntry{ovfEntry, diskEntry},
+			wantErr: "has no manifest to verify it with",
+		},
+		{
+			name:    "source without a manifest",
+			entries: []ovaEntry{ovfEntry, diskEntry},
+			source:  true,
+		},
+		{
+			name:    "source with a bad manifest",
+			entries: []ovaEntry{ovfEntry, {Name: "test-disk001.vmdk", Body: "tampered", Typeflag: tar.TypeReg}, mfEntry},
+			source:  true,
+			wantErr: "'test-disk001.vmdk' does not match its SHA256 digest",
+		},
+		{
+			name:    "disk hash mismatch",
+			entries: []ovaEntry{ovfEntry, {Name: "test-disk001.vmdk", Body: "tampered", Typeflag: tar.TypeReg}, mfEntry},
+			wantErr: "'test-disk001.vmdk' does not match its SHA256 digest",
+		},
+		{
+			name: "descriptor hash mismatch",
+			entries: []ovaEntry{{Name: "test.ovf", Body: ovf + "\n", Typeflag: tar.TypeReg}, diskEntry,
+				{Name: "test.mf", Body: manifest, Typeflag: tar.TypeReg},
+			},
+			wantErr: "'test.ovf' does not match its SHA256 digest",
+		},
+		{
+			name:    "file not in the manifest",
+			entries: []ovaEntry{ovfEntry, diskEntry, {Name: "extra.txt", Body: "extra", Typeflag: tar.TypeReg}, mfEntry},
+			wantErr: "'extra.txt' is not in the manifest",
+		},
+		{
+			name: "manifest lists a missing file",
+			entries: []ovaEntry{ovfEntry, diskEntry,
+				{Name: "test.mf", Body: manifest + digestLine("SHA256", "test-disk002.vmdk", disk), Typeflag: tar.TypeReg},
+			},
+			wantErr: "lists 'test-disk002.vmdk', which is not in the OVA",
+		},
+		{
+			name: "missing disk",
+			entries: []ovaEntry{ovfEntry,
+				{Name: "test.mf", Body: digestLine("SHA256", "test.ovf", ovf), Typeflag: tar.TypeReg},
+			},
+			wantErr: "is missing disk test-disk001.vmdk",
+		},
+		{
+			name: "invalid manifest",
+			entries: []ovaEntry{ovfEntry, diskEntry,
+				{Name: "test.mf", Body: "MD5(test.ovf)= 00\n", Typeflag: tar.TypeReg},
+			},
+			wantErr: "Invalid OVA manifest line",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			workDir := dir + "/work"
+			os.Mkdir(workDir, 0777)
+			ovaPath := writeTestOVA(t, dir, test.entries)
+
+			disks, hardware, err := VBoxExtractDisks(context.Background(), log.New(ioutil.Discard, "", 0), workDir, ovaPath, !test.source)
+			// Nothing is ever written outside the extraction directory
+			for _, outside := range []string{dir + "/test-disk001.vmdk", workDir + "/test-disk001.vmdk", "/tmp/test-disk001.vmdk"} {
+				_, serr := os.Lstat(outside)
+				if serr == nil {
+					t.Errorf("VBoxExtractDisks() wrote %s", outside)
+				}
+			}
+			if test.wantErr != "" {
+				if err == nil {
+					t.Fatalf("VBoxExtractDisks() succeeded, want error containing %q", test.wantErr)
+				}
+				if !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("VBoxExtractDisks() error = %q, want it to contain %q", err, test.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("VBoxExtractDisks() error = %v", err)
+			}
+			wantPath := workDir + "/" + ovaDir + "/test-disk001.vmdk"
+			if len(disks) != 1 || disks[0] != wantPath {
+				t.Fatalf("VBoxExtractDisks() disks = %v, want [%s]", disks, wantPath)
+			}
+			data, rerr := ioutil.ReadFile(disks[0])
+			if rerr != nil {
+				t.Fatal(rerr)
+			}
+			if string(data) != disk {
+				t.Errorf("extracted disk has %q, want %q", data, disk)
+			}
+			bootDisk, _ := hardware.BootDisk()
+			if hardware.CPUs != 1 || hardware.MemoryMB != 1024 || bootDisk.Controller != ControllerSATA {
+				t.Errorf("VBoxExtractDisks() hardware = %+v", hardware)
+			}
+		})
+	}
+}
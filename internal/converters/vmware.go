@@ -0,0 +1,230 @@
+package converters
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bocajspear1/vmifactory/internal/helpers"
+)
+
+const vmwareDir = "vmware-disks"
+
+// VMDK subformats
+const (
+	VMDKMonolithicSparse = "monolithicSparse"
+	VMDKStreamOptimized  = "streamOptimized"
+)
+
+// vmwareHardwareVersion is supported by Workstation 14 and ESXi 6.7 and newer
+const vmwareHardwareVersion = "14"
+
+// vmwareNICTypes maps OVF network adapter types to VMware's, e1000 if missing
+var vmwareNICTypes = map[string]string{
+	"e1000":   "e1000",
+	"e1000e":  "e1000e",
+	"pcnet32": "vlance",
+	"pcnet":   "vlance",
+	"virtio":  "vmxnet3",
+	"vmxnet3": "vmxnet3",
+}
+
+// vmwareSCSITypes maps OVF SCSI controller models to VMware's, lsilogic if missing
+var vmwareSCSITypes = map[string]string{
+	"lsilogic":    "lsilogic",
+	"lsilogicsas": "lsisas1068",
+	"buslogic":    "buslogic",
+	"virtio-scsi": "pvscsi",
+	"virtualscsi": "pvscsi",
+}
+
+// vmwareGuestOS maps VirtualBox OS types to VMware guest OS names
+var vmwareGuestOS = map[string]string{
+	"Debian":         "debian10",
+	"Debian_64":      "debian10-64",
+	"Ubuntu":         "ubuntu",
+	"Ubuntu_64":      "ubuntu-64",
+	"RedHat":         "rhel8",
+	"RedHat_64":      "rhel8-64",
+	"Fedora":         "fedora",
+	"Fedora_64":      "fedora-64",
+	"OpenSUSE_64":    "opensuse-64",
+	"ArchLinux_64":   "other5xlinux-64",
+	"FreeBSD_64":     "freebsd-64",
+	"Windows10":      "windows9",
+	"Windows10_64":   "windows9-64",
+	"Windows11_64":   "windows11-64",
+	"Windows2016_64": "windows9srv-64",
+	"Windows2019_64": "windows2019srv-64",
+	"Windows2022_64": "windows2019srvNext-64",
+}
+
+// vmwareGuestOSName returns the VMware guest OS for a VirtualBox OS type
+func vmwareGuestOSName(osType string) string {
+	guestOS, ok := vmwareGuestOS[osType]
+	if ok {
+		return guestOS
+	}
+	if strings.HasPrefix(osType, "Windows") {
+		return "windows9-64"
+	}
+	if strings.HasSuffix(osType, "_64") {
+		return "otherlinux-64"
+	}
+	return "otherlinux"
+}
+
+// vmwareIDESlots is how many disks fit on the two IDE controllers
+const vmwareIDESlots = 4
+
+// vmwareDiskSlot returns the controller and unit of the disk at index in
+// the VMX, like "scsi0:1". SCSI unit 7 is the controller itself. Disks
+// that do not fit on IDE go on the SCSI controller.
+func vmwareDiskSlot(controller string, index int) string {
+	switch controller {
+	case ControllerIDE:
+		if index >= vmwareIDESlots {
+			return vmwareDiskSlot(ControllerSCSI, index-vmwareIDESlots)
+		}
+		return "ide" + strconv.Itoa(index/2) + ":" + strconv.Itoa(index%2)
+	case ControllerSATA:
+		return "sata0:" + strconv.Itoa(index)
+	}
+	if index >= 7 {
+		index++
+	}
+	return "scsi0:" + strconv.Itoa(index)
+}
+
+// vmxQuote quotes a VMX value
+func vmxQuote(value string) string {
+	return `"` + strings.ReplaceAll(value, `"`, `|22`) + `"`
+}
+
+// generateVMX returns a VMX file for the hardware with the disks, the first being the boot disk
+func generateVMX(vmName string, hardware *OVFHardware, diskNames []string) (string, error) {
+	lines := [][2]string{
+		{".encoding", "UTF-8"},
+		{"config.version", "8"},
+		{"virtualHW.version", vmwareHardwareVersion},
+		{"displayName", vmName},
+		{"guestOS", vmwareGuestOSName(hardware.OSType)},
+		{"numvcpus", strconv.Itoa(hardware.CPUs)},
+		{"memsize", strconv.FormatInt(hardware.MemoryMB, 10)},
+	}
+
+	if hardware.Firmware == FirmwareEFI {
+		lines = append(lines, [2]string{"firmware", "efi"})
+	}
+
+	// Every disk goes on a controller like the one of the boot disk, IDE
+	// disks past the fourth on SCSI
+	bootDisk, berr := hardware.BootDisk()
+	if berr != nil {
+		return "", berr
+	}
+	switch bootDisk.Controller {
+	case ControllerIDE:
+		lines = append(lines, [2]string{"ide0.present", "TRUE"})
+		if len(diskNames) > 2 {
+			lines = append(lines, [2]string{"ide1.present", "TRUE"})
+		}
+		if len(diskNames) > vmwareIDESlots {
+			lines = append(lines, [2]string{"scsi0.present", "TRUE"}, [2]string{"scsi0.virtualDev", "lsilogic"})
+		}
+	case ControllerSATA:
+		lines = append(lines, [2]string{"sata0.present", "TRUE"})
+	default:
+		scsiType, ok := vmwareSCSITypes[strings.ToLower(bootDisk.ControllerSubType)]
+		if !ok {
+			scsiType = "lsilogic"
+		}
+		lines = append(lines, [2]string{"scsi0.present", "TRUE"}, [2]string{"scsi0.virtualDev", scsiType})
+	}
+	for i, diskName := range diskNames {
+		slot := vmwareDiskSlot(bootDisk.Controller, i)
+		lines = append(lines,
+			[2]string{slot + ".present", "TRUE"},
+			[2]string{slot + ".fileName", diskName},
+			[2]string{slot + ".deviceType", "disk"},
+		)
+	}
+
+	for i, nicType := range hardware.NICTypes {
+		vmwareType, ok := vmwareNICTypes[strings.ToLower(nicType)]
+		if !ok {
+			vmwareType = "e1000"
+		}
+		prefix := "ethernet" + strconv.Itoa(i)
+		lines = append(lines,
+			[2]string{prefix + ".present", "TRUE"},
+			[2]string{prefix + ".virtualDev", vmwareType},
+			[2]string{prefix + ".connectionType", "nat"},
+			[2]string{prefix + ".addressType", "generated"},
+		)
+	}
+
+	var vmx strings.Builder
+	for _, line := range lines {
+		vmx.WriteString(line[0] + " = " + vmxQuote(line[1]) + "\n")
+	}
+	return vmx.String(), nil
+}
+
+// DisksToVMware converts the disks to VMDKs of the subformat, the first
+// being the boot disk, and packs them with a VMX generated from the
+// hardware at outputPath. outputPath ending in .zip is zipped, otherwise
+// it is a gzipped tar.
+func DisksToVMware(ctx context.Context, logger *log.Logger, workDir string, vmName string, hardware *OVFHardware, diskList []string, subformat string, outputPath string) error {
+	// The VMX needs the boot disk's controller, check it before converting
+	_, berr := hardware.BootDisk()
+	if berr != nil {
+		return berr
+	}
+	disksDir := workDir + "/" + vmwareDir
+	os.Mkdir(disksDir, 0777)
+
+	convertedList := make([]string, len(diskList))
+	diskNames := make([]string, len(diskList))
+	logger.Println("(VMware) Converting disks...")
+	for i, diskFile := range diskList {
+		// Disks are named after the VM, the order is kept for multiple disks
+		if len(diskList) == 1 {
+			diskNames[i] = vmName + ".vmdk"
+		} else {
+			diskNames[i] = vmName + "-disk" + strconv.Itoa(i+1) + ".vmdk"
+		}
+		convertedList[i] = disksDir + "/" + diskNames[i]
+		output, cerr := DiskToVMDK(ctx, diskFile, convertedList[i], subformat)
+		if cerr != nil {
+			return cerr
+		}
+		logger.Printf("%s", output)
+	}
+
+	vmxPath := disksDir + "/" + vmName + ".vmx"
+	vmx, gerr := generateVMX(vmName, hardware, diskNames)
+	if gerr != nil {
+		return gerr
+	}
+	werr := ioutil.WriteFile(vmxPath, []byte(vmx), 0644)
+	if werr != nil {
+		return werr
+	}
+
+	packFiles := append(convertedList, vmxPath)
+	if strings.HasSuffix(outputPath, ".zip") {
+		logger.Println("(VMware) Building Zip...")
+		return helpers.ZipFiles(packFiles, outputPath)
+	}
+	logger.Println("(VMware) Building Gzipped Tar...")
+	return helpers.TarAndGzipFiles(packFiles, outputPath)
+}
+
+// VMwareCleanup cleans up conversion artifacts
+func VMwareCleanup(workDir string) {
+	os.RemoveAll(workDir + "/" + vmwareDir)
+}
@@ -0,0 +1,68 @@
+package converters
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVMwareDiskSlot(t *testing.T) {
+	tests := []struct {
+		controller string
+		index      int
+		want       string
+	}{
+		{ControllerIDE, 0, "ide0:0"},
+		{ControllerIDE, 1, "ide0:1"},
+		{ControllerIDE, 3, "ide1:1"},
+		{ControllerIDE, 4, "scsi0:0"},
+		{ControllerIDE, 5, "scsi0:1"},
+		{ControllerSATA, 4, "sata0:4"},
+		{ControllerSCSI, 6, "scsi0:6"},
+		{ControllerSCSI, 7, "scsi0:8"},
+	}
+	for _, test := range tests {
+		got := vmwareDiskSlot(test.controller, test.index)
+		if got != test.want {
+			t.Errorf("vmwareDiskSlot(%q, %d) = %q, want %q", test.controller, test.index, got, test.want)
+		}
+	}
+}
+
+func TestGenerateVMXExtraIDEDisks(t *testing.T) {
+	hardware := &OVFHardware{
+		CPUs:     1,
+		MemoryMB: 1024,
+		Firmware: FirmwareBIOS,
+		Disks:    []OVFDiskInfo{{File: "disk1.vmdk", Controller: ControllerIDE}},
+	}
+	diskNames := []string{"d1.vmdk", "d2.vmdk", "d3.vmdk", "d4.vmdk", "d5.vmdk", "d6.vmdk"}
+	vmx, gerr := generateVMX("test", hardware, diskNames)
+	if gerr != nil {
+		t.Fatalf("generateVMX() error = %v", gerr)
+	}
+	for _, want := range []string{
+		`ide0.present = "TRUE"`,
+		`ide1.present = "TRUE"`,
+		`ide1:1.fileName = "d4.vmdk"`,
+		`scsi0.present = "TRUE"`,
+		`scsi0.virtualDev = "lsilogic"`,
+		`scsi0:0.fileName = "d5.vmdk"`,
+		`scsi0:1.fileName = "d6.vmdk"`,
+	} {
+		if !strings.Contains(vmx, want) {
+			t.Errorf("generateVMX() does not have %q:\n%s", want, vmx)
+		}
+	}
+
+	vmx, _ = generateVMX("test", hardware, diskNames[:4])
+	if strings.Contains(vmx, "scsi0") {
+		t.Errorf("generateVMX() with 4 IDE disks adds a SCSI controller:\n%s", vmx)
+	}
+}
+
+func TestGenerateVMXWithoutDisks(t *testing.T) {
+	_, gerr := generateVMX("test", &OVFHardware{CPUs: 1, MemoryMB: 1024}, []string{"d1.vmdk"})
+	if gerr == nil {
+		t.Error("generateVMX() without a boot disk succeeded")
+	}
+}
@@ -2,12 +2,14 @@ package helpers
 
 import (
 	"archive/tar"
+	"archive/zip"
 	"compress/gzip"
 	"crypto/sha256"
 	"encoding/hex"
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 )
 
 func CopyFile(src string, dst string) error {
@@ -58,6 +60,42 @@ func TarAndGzipFiles(files []string, outputFilePath string) error {
 	return nil
 }
 
+// ZipFiles packs the files into a zip archive, each under its base name
+func ZipFiles(files []string, outputFilePath string) error {
+	outputFile, err := os.Create(outputFilePath)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+	zipWriter := zip.NewWriter(outputFile)
+
+	for _, inputFilePath := range files {
+		inputFileInfo, err := os.Stat(inputFilePath)
+		if err != nil {
+			return err
+		}
+		header, err := zip.FileInfoHeader(inputFileInfo)
+		if err != nil {
+			return err
+		}
+		header.Method = zip.Deflate
+		entryWriter, err := zipWriter.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		inputFile, err := os.Open(inputFilePath)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(entryWriter, inputFile)
+		inputFile.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return zipWriter.Close()
+}
+
 func GetFileSHA256(filepath string) (string, error) {
 	hasher := sha256.New()
 	inFile, err := ioutil.ReadFile(filepath)
@@ -68,3 +106,53 @@ func GetFileSHA256(filepath string) (string, error) {
 
 	return hex.EncodeToString(hasher.Sum(nil)), nil
 }
+
+// existingParent returns the path, or its closest parent that exists
+func existingParent(path string) string {
+	path = filepath.Clean(path)
+	for {
+		_, err := os.Stat(path)
+		parent := filepath.Dir(path)
+		if err == nil || parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+// SyncDir flushes a directory's entries, making renames in it durable
+func SyncDir(dirPath string) error {
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// WriteFileAtomic writes a file through a temporary file that is flushed
+// and renamed over the target, so the file is either old or new after a crash
+func WriteFileAtomic(filePath string, data []byte, perm os.FileMode) error {
+	tempPath := filePath + ".tmp"
+	tempFile, err := os.OpenFile(tempPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	_, err = tempFile.Write(data)
+	if err == nil {
+		err = tempFile.Sync()
+	}
+	cerr := tempFile.Close()
+	if err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	err = os.Rename(tempPath, filePath)
+	if err != nil {
+		return err
+	}
+	return SyncDir(filepath.Dir(filePath))
+}
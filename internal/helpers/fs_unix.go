@@ -0,0 +1,28 @@
+//go:build !windows
+
+package helpers
+
+import (
+	"os"
+	"syscall"
+)
+
+// SameFilesystem checks if two paths are on the same filesystem, so files
+// can be renamed from one to the other. Paths that do not exist yet are
+// checked by their closest existing parent.
+func SameFilesystem(first string, second string) (bool, error) {
+	firstInfo, ferr := os.Stat(existingParent(first))
+	if ferr != nil {
+		return false, ferr
+	}
+	secondInfo, serr := os.Stat(existingParent(second))
+	if serr != nil {
+		return false, serr
+	}
+	firstStat, ok := firstInfo.Sys().(*syscall.Stat_t)
+	secondStat, ok2 := secondInfo.Sys().(*syscall.Stat_t)
+	if !ok || !ok2 {
+		return true, nil
+	}
+	return firstStat.Dev == secondStat.Dev, nil
+}
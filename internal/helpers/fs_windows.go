@@ -0,0 +1,22 @@
+//go:build windows
+
+package helpers
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// SameFilesystem checks if two paths are on the same volume, so files can
+// be renamed from one to the other
+func SameFilesystem(first string, second string) (bool, error) {
+	firstAbs, ferr := filepath.Abs(existingParent(first))
+	if ferr != nil {
+		return false, ferr
+	}
+	secondAbs, serr := filepath.Abs(existingParent(second))
+	if serr != nil {
+		return false, serr
+	}
+	return strings.EqualFold(filepath.VolumeName(firstAbs), filepath.VolumeName(secondAbs)), nil
+}
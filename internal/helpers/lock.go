@@ -0,0 +1,6 @@
+package helpers
+
+import "errors"
+
+// ErrFileLocked is returned by LockFile when another open file holds the lock
+var ErrFileLocked = errors.New("File is locked by another process")
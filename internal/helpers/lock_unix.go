@@ -0,0 +1,29 @@
+//go:build !windows
+
+package helpers
+
+import (
+	"os"
+	"syscall"
+)
+
+// LockFile takes an advisory lock on the open file without waiting for
+// it, shared or exclusive. The kernel releases it when the file is
+// closed or the process dies. ErrFileLocked is returned if another open
+// file holds a conflicting lock.
+func LockFile(file *os.File, shared bool) error {
+	how := syscall.LOCK_EX
+	if shared {
+		how = syscall.LOCK_SH
+	}
+	err := syscall.Flock(int(file.Fd()), how|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return ErrFileLocked
+	}
+	return err
+}
+
+// UnlockFile releases a lock taken with LockFile
+func UnlockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
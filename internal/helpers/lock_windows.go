@@ -0,0 +1,55 @@
+//go:build windows
+
+package helpers
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+	errorLockViolation      = syscall.Errno(33)
+)
+
+// lockRange returns the byte range that is locked. Windows locks keep
+// others from reading the range, so it is past anything written.
+func lockRange() *syscall.Overlapped {
+	return &syscall.Overlapped{Offset: 0xFFFFFFFF, OffsetHigh: 0x7FFFFFFF}
+}
+
+// LockFile takes a lock on the open file without waiting for it, shared
+// or exclusive. Windows releases it when the file is closed or the
+// process dies. ErrFileLocked is returned if another open file holds a
+// conflicting lock.
+func LockFile(file *os.File, shared bool) error {
+	flags := uintptr(lockfileFailImmediately)
+	if !shared {
+		flags |= lockfileExclusiveLock
+	}
+	result, _, err := procLockFileEx.Call(file.Fd(), flags, 0, 1, 0, uintptr(unsafe.Pointer(lockRange())))
+	if result == 0 {
+		if err == errorLockViolation {
+			return ErrFileLocked
+		}
+		return err
+	}
+	return nil
+}
+
+// UnlockFile releases a lock taken with LockFile
+func UnlockFile(file *os.File) error {
+	result, _, err := procUnlockFileEx.Call(file.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(lockRange())))
+	if result == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build windows
+
+package helpers
+
+import "os"
+
+// ProcessAlive checks if a process with the PID is running on this host
+func ProcessAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	process.Release()
+	return true
+}
@@ -0,0 +1,210 @@
+package imagemanage
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bocajspear1/vmifactory/internal/packer"
+)
+
+// Ansible modes, set by the 'ansible'/'mode' config key
+const (
+	AnsibleRemote = "remote"
+	AnsibleLocal  = "local"
+)
+
+// varBecomeVars holds the extra vars giving Ansible the sudo password, as JSON
+const varBecomeVars = "ansible_become_vars"
+
+// ansibleStagingPath is where ansible-local gets the sudo password from.
+// The directory is only readable by the login user.
+const ansibleStagingPath = "/tmp/vmifactory-ansible"
+
+// ansiblePlugin has both the ansible and ansible-local provisioners
+var ansiblePlugin = packer.Plugin{Name: "ansible", Source: "github.com/hashicorp/ansible", Version: ">= 1.0.0"}
+
+// GetAnsiblePath returns the path to the Ansible directory
+func (v VMImage) GetAnsiblePath() string {
+	return v.ImageRootDir + "/ansible"
+}
+
+// GetAnsiblePlaybook returns the path to the playbook run in every build
+func (v VMImage) GetAnsiblePlaybook() string {
+	return v.GetAnsiblePath() + "/playbook.yml"
+}
+
+// GetAnsibleRunOncePath returns the path to the run once playbook directory
+func (v VMImage) GetAnsibleRunOncePath() string {
+	return v.GetAnsiblePath() + "/runonce"
+}
+
+// GetAnsibleMode returns how Ansible is run, remote unless set
+func (v VMImage) GetAnsibleMode() string {
+	if v.Config.Ansible.Mode == "" {
+		return AnsibleRemote
+	}
+	return v.Config.Ansible.Mode
+}
+
+// isPlaybook checks if a file name is a YAML playbook
+func isPlaybook(fileName string) bool {
+	return strings.HasSuffix(fileName, ".yml") || strings.HasSuffix(fileName, ".yaml")
+}
+
+// listAnsiblePlaybooks returns the playbooks to run, the runonce playbooks
+// first in alphabetical order, then playbook.yml
+func (v VMImage) listAnsiblePlaybooks() ([]string, error) {
+	playbooks := make([]string, 0)
+
+	runOncePlaybooks, err := ioutil.ReadDir(v.GetAnsibleRunOncePath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.New("Could not list the Ansible runonce directory for the image")
+	}
+	for _, playbook := range runOncePlaybooks {
+		if !playbook.IsDir() && isPlaybook(playbook.Name()) {
+			playbooks = append(playbooks, v.GetAnsibleRunOncePath()+"/"+playbook.Name())
+		}
+	}
+
+	if _, serr := os.Stat(v.GetAnsiblePlaybook()); serr == nil {
+		playbooks = append(playbooks, v.GetAnsiblePlaybook())
+	}
+	return playbooks, nil
+}
+
+// HasAnsible checks if the image has Ansible playbooks to run
+func (v VMImage) HasAnsible() bool {
+	playbooks, err := v.listAnsiblePlaybooks()
+	return err == nil && len(playbooks) > 0
+}
+
+// ansibleBecomeVars returns the extra vars with the sudo password. JSON
+// keeps passwords with spaces or quotes in one piece.
+func ansibleBecomeVars(sudoPassword string) (string, error) {
+	data, err := json.Marshal(map[string]string{"ansible_become_password": sudoPassword})
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// ansibleRolesPaths returns the absolute paths of the role directories,
+// the image's own roles first
+func (v VMImage) ansibleRolesPaths() []string {
+	rolesPaths := []string{v.imageFilePath("ansible/roles")}
+	for _, rolesPath := range v.Config.Ansible.RolesPaths {
+		rolesPaths = append(rolesPaths, v.imageFilePath(rolesPath))
+	}
+	return rolesPaths
+}
+
+// ansibleProvisioners returns a provisioner for each playbook
+func (v VMImage) ansibleProvisioners(template *packer.Template) ([]packer.Provisioner, error) {
+	playbooks, lerr := v.listAnsiblePlaybooks()
+	if lerr != nil {
+		return nil, lerr
+	}
+	if len(playbooks) == 0 {
+		return nil, nil
+	}
+
+	template.AddPlugin(ansiblePlugin)
+	template.AddVariable(packer.Variable{Name: varBecomeVars, Sensitive: true})
+
+	// ansible-local runs ansible-playbook through the guest's shell, so
+	// the password is uploaded to a file instead of being an argument
+	becomeVarsFile := ansibleStagingPath + "/become.json"
+	extraArguments := []interface{}{"--become", "--extra-vars"}
+	if v.GetAnsibleMode() == AnsibleLocal {
+		extraArguments = append(extraArguments, "@"+becomeVarsFile)
+	} else {
+		extraArguments = append(extraArguments, packer.VarRef(varBecomeVars))
+	}
+	for _, argument := range v.Config.Ansible.ExtraArguments {
+		extraArguments = append(extraArguments, argument)
+	}
+
+	requirementsPath, _ := filepath.Abs(v.GetAnsiblePath() + "/requirements.yml")
+	_, rerr := os.Stat(requirementsPath)
+	hasRequirements := rerr == nil
+
+	// The local provisioner uploads each role directory on its own
+	var localRoles []string
+	if v.GetAnsibleMode() == AnsibleLocal {
+		localRoles = make([]string, 0)
+		for _, rolesPath := range v.ansibleRolesPaths()[1:] {
+			roles, err := ioutil.ReadDir(rolesPath)
+			if err != nil {
+				return nil, errors.New("Could not list the Ansible roles in " + rolesPath)
+			}
+			for _, role := range roles {
+				if role.IsDir() {
+					localRoles = append(localRoles, rolesPath+"/"+role.Name())
+				}
+			}
+		}
+	}
+
+	provisioners := make([]packer.Provisioner, 0, len(playbooks)+3)
+	if v.GetAnsibleMode() == AnsibleLocal {
+		provisioners = append(provisioners,
+			packer.Provisioner{
+				Type: "shell",
+				Settings: packer.Settings{
+					"inline": []string{"rm -rf " + ansibleStagingPath, "mkdir -m 700 " + ansibleStagingPath},
+				},
+			},
+			packer.Provisioner{
+				Type: "file",
+				Settings: packer.Settings{
+					"content":     packer.VarRef(varBecomeVars),
+					"destination": becomeVarsFile,
+				},
+			},
+		)
+	}
+	for _, playbook := range playbooks {
+		playbookPath, _ := filepath.Abs(playbook)
+		provisioner := packer.Provisioner{
+			Settings: packer.Settings{
+				"playbook_file":   playbookPath,
+				"extra_arguments": extraArguments,
+			},
+		}
+		if hasRequirements {
+			provisioner.Settings["galaxy_file"] = requirementsPath
+		}
+
+		if v.GetAnsibleMode() == AnsibleLocal {
+			// The whole directory is uploaded and the playbook next to it,
+			// so the image's roles are found beside every playbook
+			provisioner.Type = "ansible-local"
+			provisioner.Settings["playbook_dir"] = v.imageFilePath("ansible")
+			if len(localRoles) > 0 {
+				provisioner.Settings["role_paths"] = localRoles
+			}
+		} else {
+			provisioner.Type = "ansible"
+			provisioner.Settings["user"] = v.Config.Login.Username
+			provisioner.Settings["ansible_env_vars"] = []string{
+				"ANSIBLE_ROLES_PATH=" + strings.Join(v.ansibleRolesPaths(), ":"),
+				// Packer's SSH proxy has a new host key every build
+				"ANSIBLE_HOST_KEY_CHECKING=False",
+			}
+		}
+		provisioners = append(provisioners, provisioner)
+	}
+	if v.GetAnsibleMode() == AnsibleLocal {
+		provisioners = append(provisioners, packer.Provisioner{
+			Type: "shell",
+			Settings: packer.Settings{
+				"inline": []string{"rm -rf " + ansibleStagingPath},
+			},
+		})
+	}
+	return provisioners, nil
+}
@@ -0,0 +1,106 @@
+package imagemanage
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/bocajspear1/vmifactory/internal/packer"
+)
+
+// newAnsibleTestImage returns an image with a playbook and the Ansible mode
+func newAnsibleTestImage(t *testing.T, mode string) VMImage {
+	v := VMImage{
+		ImageName:    "test",
+		ImageRootDir: t.TempDir(),
+		Config: &BuilderConfig{
+			Login:   LoginConfig{Username: "builder"},
+			Ansible: AnsibleConfig{Mode: mode, ExtraArguments: []string{"-v"}},
+		},
+	}
+	os.Mkdir(v.GetAnsiblePath(), 0777)
+	werr := ioutil.WriteFile(v.GetAnsiblePlaybook(), []byte("- hosts: all\n"), 0644)
+	if werr != nil {
+		t.Fatal(werr)
+	}
+	return v
+}
+
+// renderAnsible renders the Ansible provisioners of the image in both formats
+func renderAnsible(t *testing.T, v VMImage) ([]packer.Provisioner, map[string]string) {
+	template := &packer.Template{}
+	provisioners, perr := v.ansibleProvisioners(template)
+	if perr != nil {
+		t.Fatalf("ansibleProvisioners() error = %v", perr)
+	}
+	template.Provisioners = provisioners
+	rendered := make(map[string]string)
+	for _, format := range []string{packer.FormatHCL, packer.FormatJSON} {
+		output, rerr := template.Render(format)
+		if rerr != nil {
+			t.Fatalf("Render(%s) error = %v", format, rerr)
+		}
+		rendered[format] = output
+	}
+	return provisioners, rendered
+}
+
+func TestAnsibleRemoteProvisioners(t *testing.T) {
+	provisioners, rendered := renderAnsible(t, newAnsibleTestImage(t, AnsibleRemote))
+	if len(provisioners) != 1 || provisioners[0].Type != "ansible" {
+		t.Fatalf("provisioners = %+v, want one ansible provisioner", provisioners)
+	}
+	want := map[string][]string{
+		packer.FormatHCL:  {"\"--extra-vars\",\n      var.ansible_become_vars,\n", `= "builder"`},
+		packer.FormatJSON: {"{{user `ansible_become_vars`}}", `"user": "builder"`},
+	}
+	for format, parts := range want {
+		for _, part := range parts {
+			if !strings.Contains(rendered[format], part) {
+				t.Errorf("%s template does not contain %s:\n%s", format, part, rendered[format])
+			}
+		}
+	}
+}
+
+func TestAnsibleLocalProvisioners(t *testing.T) {
+	provisioners, rendered := renderAnsible(t, newAnsibleTestImage(t, AnsibleLocal))
+	types := make([]string, len(provisioners))
+	for i, provisioner := range provisioners {
+		types[i] = provisioner.Type
+	}
+	if strings.Join(types, ",") != "shell,file,ansible-local,shell" {
+		t.Fatalf("provisioner types = %v, want the password file set up and removed around ansible-local", types)
+	}
+	if provisioners[0].Settings["inline"].([]string)[1] != "mkdir -m 700 "+ansibleStagingPath {
+		t.Errorf("password directory is created with %v, want mode 700", provisioners[0].Settings["inline"])
+	}
+	if provisioners[3].Settings["inline"].([]string)[0] != "rm -rf "+ansibleStagingPath {
+		t.Errorf("password directory is removed with %v", provisioners[3].Settings["inline"])
+	}
+
+	want := map[string][]string{
+		packer.FormatHCL: {
+			`content     = var.ansible_become_vars`,
+			"\"--extra-vars\",\n      \"@/tmp/vmifactory-ansible/become.json\",\n",
+		},
+		packer.FormatJSON: {
+			"\"content\": \"{{user `ansible_become_vars`}}\"",
+			`"@/tmp/vmifactory-ansible/become.json"`,
+		},
+	}
+	for format, parts := range want {
+		for _, part := range parts {
+			if !strings.Contains(rendered[format], part) {
+				t.Errorf("%s template does not contain %s:\n%s", format, part, rendered[format])
+			}
+		}
+	}
+	// The password is only in the uploaded file, never an argument
+	for _, argument := range provisioners[2].Settings["extra_arguments"].([]interface{}) {
+		if _, ok := argument.(packer.VarRef); ok {
+			t.Errorf("ansible-local argument %v is a variable", argument)
+		}
+	}
+}
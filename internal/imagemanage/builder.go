@@ -0,0 +1,67 @@
+package imagemanage
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/bocajspear1/vmifactory/internal/packer"
+)
+
+// Builder handles the parts of a build that depend on the hypervisor
+// of the source image, set by the 'source'/'hypervisor' config key.
+type Builder interface {
+	// PackerBuilder returns the Packer source with its hypervisor specific settings
+	PackerBuilder(v VMImage) (packer.Source, error)
+	// PackerPlugin returns the Packer plugin that provides the source
+	PackerPlugin() packer.Plugin
+	// StagedPath returns the path of the working copy of the source image
+	StagedPath(v VMImage) string
+	// StageSource copies the source image into the work directory
+	StageSource(v VMImage) error
+	// ArtifactPath returns the path of the image produced by Packer
+	ArtifactPath(v VMImage) string
+	// Convert creates the outputs for the other hypervisors from the artifact
+	Convert(ctx context.Context, v VMImage) error
+	// Finalize moves the artifact to realName in the work directory for committing
+	Finalize(v VMImage, realName string) error
+	// Outputs returns the 'out' keys the builder can produce
+	Outputs() []string
+	// SourceExtension returns the file extension of source images, like ".ova"
+	SourceExtension() string
+}
+
+var builders = make(map[string]Builder)
+
+// RegisterBuilder makes a builder available for the given source hypervisor
+func RegisterBuilder(hypervisor string, builder Builder) {
+	builders[hypervisor] = builder
+}
+
+// GetBuilder returns the builder for the given source hypervisor
+func GetBuilder(hypervisor string) (Builder, error) {
+	builder, ok := builders[hypervisor]
+	if !ok {
+		return nil, errors.New("Builder '" + hypervisor + "' is not supported")
+	}
+	return builder, nil
+}
+
+// GetBuilderNames returns the names of the registered builders
+func GetBuilderNames() []string {
+	names := make([]string, 0, len(builders))
+	for name := range builders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// getBuilder returns the builder for the source hypervisor of the image
+func (v VMImage) getBuilder() (Builder, error) {
+	builderString := v.Config.Source.Hypervisor
+	if builderString == "" {
+		return nil, errors.New("Required key 'source'/'hypervisor' not found")
+	}
+	return GetBuilder(builderString)
+}
@@ -0,0 +1,91 @@
+package imagemanage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/bocajspear1/vmifactory/internal/converters"
+	"github.com/bocajspear1/vmifactory/internal/helpers"
+	"github.com/bocajspear1/vmifactory/internal/packer"
+)
+
+// kvmBuilder builds from QCOW2 disk images with the qemu builder
+type kvmBuilder struct{}
+
+func init() {
+	RegisterBuilder("kvm", kvmBuilder{})
+}
+
+func (b kvmBuilder) PackerBuilder(v VMImage) (packer.Source, error) {
+	builderOut := make(packer.Settings)
+	builderOut["disk_image"] = true
+	builderOut["format"] = "qcow2"
+	builderOut["headless"] = true
+	builderOut["iso_url"], _ = filepath.Abs(b.StagedPath(v))
+	builderOut["iso_checksum"] = "none"
+	return packer.Source{Type: "qemu", Name: "kvm", Settings: builderOut}, nil
+}
+
+func (b kvmBuilder) PackerPlugin() packer.Plugin {
+	return packer.Plugin{Name: "qemu", Source: "github.com/hashicorp/qemu", Version: ">= 1.0.0"}
+}
+
+func (b kvmBuilder) StagedPath(v VMImage) string {
+	return v.GetWorkDirPath() + "/original.qcow2"
+}
+
+func (b kvmBuilder) StageSource(v VMImage) error {
+	return helpers.CopyFile(v.ImageRootDir+"/"+v.Config.Source.ImageFile, b.StagedPath(v))
+}
+
+func (b kvmBuilder) ArtifactPath(v VMImage) string {
+	// The qemu builder names its output after vm_name, without an extension
+	return v.GetWorkDirPath() + "/packer-out/" + v.ImageName + "-vmifactory"
+}
+
+func (b kvmBuilder) Convert(ctx context.Context, v VMImage) error {
+	for hypervisor := range v.Config.Out.Files() {
+		if !stringInList(hypervisor, b.Outputs()) {
+			v.logger().Println("No KVM conversion available for " + hypervisor + ", skipping...")
+		}
+	}
+
+	// Do conversion for Hyper-V
+	hypervName := v.Config.Out.HyperV
+	if hypervName != "" {
+		v.logger().Println("Doing HyperV conversion...")
+		cerr := converters.DisksToHyperV(ctx, v.logger(), v.GetWorkDirPath(), v.ImageName, "", []string{b.ArtifactPath(v)}, v.GetWorkDirPath()+"/"+hypervName)
+		converters.HyperVCleanup(v.GetWorkDirPath())
+		if cerr != nil {
+			return cerr
+		}
+	}
+
+	// Do conversion for Vagrant, the box has a copy of the QCOW2
+	vagrantLibvirtName := v.Config.Out.VagrantLibvirt
+	if vagrantLibvirtName != "" {
+		v.logger().Println("Doing Vagrant libvirt conversion...")
+		cerr := converters.DisksToVagrantLibvirt(ctx, v.logger(), v.GetWorkDirPath(), v.vagrantGuest(), []string{b.ArtifactPath(v)}, v.GetWorkDirPath()+"/"+vagrantLibvirtName)
+		converters.VagrantCleanup(v.GetWorkDirPath())
+		if cerr != nil {
+			return cerr
+		}
+	}
+	return nil
+}
+
+func (b kvmBuilder) Finalize(v VMImage, realName string) error {
+	// Remove our working copy
+	os.Remove(b.StagedPath(v))
+	// Move the new Packer copy out
+	return os.Rename(b.ArtifactPath(v), v.GetWorkDirPath()+"/"+realName)
+}
+
+func (b kvmBuilder) Outputs() []string {
+	return []string{"kvm", "hyperv", "vagrant_libvirt"}
+}
+
+func (b kvmBuilder) SourceExtension() string {
+	return ".qcow2"
+}
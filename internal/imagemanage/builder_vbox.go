@@ -0,0 +1,137 @@
+package imagemanage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/bocajspear1/vmifactory/internal/converters"
+	"github.com/bocajspear1/vmifactory/internal/helpers"
+	"github.com/bocajspear1/vmifactory/internal/packer"
+)
+
+// vboxBuilder builds from VirtualBox OVAs with the virtualbox-ovf builder
+type vboxBuilder struct{}
+
+func init() {
+	RegisterBuilder("vbox", vboxBuilder{})
+}
+
+func (b vboxBuilder) PackerBuilder(v VMImage) (packer.Source, error) {
+	builderOut := make(packer.Settings)
+	builderOut["guest_additions_mode"] = "attach"
+	builderOut["format"] = "ova"
+	builderOut["headless"] = true
+	builderOut["source_path"], _ = filepath.Abs(b.StagedPath(v))
+	// The manifest lets the OVA be verified when it is converted
+	builderOut["export_opts"] = []string{"--manifest"}
+	return packer.Source{Type: "virtualbox-ovf", Name: "vbox", Settings: builderOut}, nil
+}
+
+func (b vboxBuilder) PackerPlugin() packer.Plugin {
+	return packer.Plugin{Name: "virtualbox", Source: "github.com/hashicorp/virtualbox", Version: ">= 1.0.0"}
+}
+
+func (b vboxBuilder) StagedPath(v VMImage) string {
+	return v.GetWorkDirPath() + "/original.ova"
+}
+
+func (b vboxBuilder) StageSource(v VMImage) error {
+	return helpers.CopyFile(v.ImageRootDir+"/"+v.Config.Source.ImageFile, b.StagedPath(v))
+}
+
+func (b vboxBuilder) ArtifactPath(v VMImage) string {
+	return v.GetWorkDirPath() + "/packer-out/" + v.ImageName + "-vmifactory.ova"
+}
+
+func (b vboxBuilder) Convert(ctx context.Context, v VMImage) error {
+	v.logger().Println("Started VBox conversions...")
+
+	// Packer always writes a manifest, source OVAs may be exported without one
+	ovaDisks, hardware, extractErr := converters.VBoxExtractDisks(ctx, v.logger(), v.GetWorkDirPath(), b.ArtifactPath(v), !v.artifactIsSource)
+
+	if extractErr != nil {
+		// Nothing extracted from an OVA that failed verification is kept
+		converters.VBoxCleanup(v.GetWorkDirPath())
+		return extractErr
+	}
+
+	herr := v.saveBuildHardware(hardware)
+	if herr != nil {
+		return herr
+	}
+
+	// Do conversion for KVM
+	kvmName := v.Config.Out.KVM
+	if kvmName != "" {
+		v.logger().Println("Doing KVM conversion...")
+		cerr := converters.VBoxToKVM(ctx, v.logger(), ovaDisks, v.GetWorkDirPath()+"/"+kvmName)
+		if cerr != nil {
+			return cerr
+		}
+	}
+
+	// Do conversion for Hyper-V
+	hypervName := v.Config.Out.HyperV
+	if hypervName != "" {
+		v.logger().Println("Doing HyperV conversion...")
+		cerr := converters.DisksToHyperV(ctx, v.logger(), v.GetWorkDirPath(), v.ImageName, hardware.Firmware, ovaDisks, v.GetWorkDirPath()+"/"+hypervName)
+		if cerr != nil {
+			return cerr
+		}
+	}
+
+	// Do conversion for VMware, the VMX is made from the OVF
+	vmwareName := v.Config.Out.VMware
+	if vmwareName != "" {
+		v.logger().Println("Doing VMware conversion...")
+		cerr := converters.DisksToVMware(ctx, v.logger(), v.GetWorkDirPath(), v.ImageName, hardware, ovaDisks, v.GetVMDKFormat(), v.GetWorkDirPath()+"/"+vmwareName)
+		if cerr != nil {
+			return cerr
+		}
+	}
+
+	// Do conversions for Vagrant, the VirtualBox box is the OVA's contents
+	vagrantVBoxName := v.Config.Out.VagrantVirtualBox
+	if vagrantVBoxName != "" {
+		v.logger().Println("Doing Vagrant VirtualBox conversion...")
+		cerr := converters.VBoxToVagrant(ctx, v.logger(), v.GetWorkDirPath(), v.vagrantGuest(), ovaDisks, v.GetWorkDirPath()+"/"+vagrantVBoxName)
+		if cerr != nil {
+			return cerr
+		}
+	}
+	vagrantLibvirtName := v.Config.Out.VagrantLibvirt
+	if vagrantLibvirtName != "" {
+		v.logger().Println("Doing Vagrant libvirt conversion...")
+		cerr := converters.DisksToVagrantLibvirt(ctx, v.logger(), v.GetWorkDirPath(), v.vagrantGuest(), ovaDisks, v.GetWorkDirPath()+"/"+vagrantLibvirtName)
+		if cerr != nil {
+			return cerr
+		}
+	}
+
+	v.logger().Println("VBox conversions completed...")
+
+	// Remove our work files
+	converters.VBoxCleanup(v.GetWorkDirPath())
+	converters.HyperVCleanup(v.GetWorkDirPath())
+	converters.VMwareCleanup(v.GetWorkDirPath())
+	converters.VagrantCleanup(v.GetWorkDirPath())
+
+	v.logger().Println("VBox cleanup completed...")
+	return nil
+}
+
+func (b vboxBuilder) Finalize(v VMImage, realName string) error {
+	// Remove our working copy
+	os.Remove(b.StagedPath(v))
+	// Move the new Packer copy out
+	return os.Rename(b.ArtifactPath(v), v.GetWorkDirPath()+"/"+realName)
+}
+
+func (b vboxBuilder) Outputs() []string {
+	return []string{"vbox", "kvm", "hyperv", "vmware", "vagrant_virtualbox", "vagrant_libvirt"}
+}
+
+func (b vboxBuilder) SourceExtension() string {
+	return ".ova"
+}
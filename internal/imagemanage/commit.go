@@ -0,0 +1,303 @@
+package imagemanage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bocajspear1/vmifactory/internal/helpers"
+)
+
+// commitStep is a single file move of a commit
+type commitStep struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Done bool   `json:"done"`
+}
+
+// commitJournal records the intent of a commit before any file is
+// touched, so an interrupted commit can be finished or rolled back. It
+// is stored at the commit flag path, so the flag is present while it exists.
+type commitJournal struct {
+	PID      int          `json:"pid"`
+	Host     string       `json:"host"`
+	Started  string       `json:"started"`
+	Steps    []commitStep `json:"steps"`
+	Backups  []string     `json:"backups"`
+	NewState *ImageState  `json:"new_state"`
+	// RollingBack is set once a rollback starts, which recovery then finishes
+	RollingBack bool `json:"rolling_back,omitempty"`
+}
+
+func (v VMImage) writeJournal(journal *commitJournal) error {
+	data, merr := json.MarshalIndent(journal, "", "    ")
+	if merr != nil {
+		return merr
+	}
+	return helpers.WriteFileAtomic(v.GetCommitFlag(), data, 0644)
+}
+
+func (v VMImage) readJournal() (*commitJournal, error) {
+	data, ferr := ioutil.ReadFile(v.GetCommitFlag())
+	if ferr != nil {
+		return nil, ferr
+	}
+	var journal commitJournal
+	jerr := json.Unmarshal(data, &journal)
+	if jerr != nil {
+		return nil, jerr
+	}
+	return &journal, nil
+}
+
+// stepMoved checks if the files show a step's move happened. Every move
+// target is cleared by an earlier step, so only the file being at To and
+// not at From means it happened.
+func stepMoved(step commitStep) bool {
+	_, ferr := os.Stat(step.From)
+	_, terr := os.Stat(step.To)
+	return os.IsNotExist(ferr) && terr == nil
+}
+
+// stepDone checks if a step's move happened
+func stepDone(step commitStep) bool {
+	return step.Done || stepMoved(step)
+}
+
+// applyJournal does the moves of the journal that have not been done
+// yet, then saves the new state and removes the backups and journal
+func (v VMImage) applyJournal(journal *commitJournal) error {
+	for i := range journal.Steps {
+		step := &journal.Steps[i]
+		if stepDone(*step) {
+			step.Done = true
+			continue
+		}
+		rerr := os.Rename(step.From, step.To)
+		if rerr != nil {
+			return rerr
+		}
+		serr := helpers.SyncDir(filepath.Dir(step.To))
+		if serr != nil {
+			return serr
+		}
+		step.Done = true
+		jerr := v.writeJournal(journal)
+		if jerr != nil {
+			return jerr
+		}
+	}
+
+	v.State = journal.NewState
+	serr := v.saveState()
+	if serr != nil {
+		return serr
+	}
+
+	// The commit is done, a leftover journal is finished again on recovery
+	for _, backup := range journal.Backups {
+		os.Remove(backup)
+	}
+	os.Remove(v.getCredentialsPath())
+	rerr := os.Remove(v.GetCommitFlag())
+	if rerr != nil {
+		v.logger().Println("Could not remove commit journal: " + rerr.Error())
+	}
+	return nil
+}
+
+// rollbackJournal undoes the moves of the journal that were done, in
+// reverse order, and removes the journal. The files decide which moves
+// are undone, since a rollback that was interrupted has undone moves the
+// journal may still have as done.
+func (v VMImage) rollbackJournal(journal *commitJournal) error {
+	journal.RollingBack = true
+	werr := v.writeJournal(journal)
+	if werr != nil {
+		return werr
+	}
+	for i := len(journal.Steps) - 1; i >= 0; i-- {
+		step := &journal.Steps[i]
+		if !stepMoved(*step) {
+			step.Done = false
+			continue
+		}
+		rerr := os.Rename(step.To, step.From)
+		if rerr != nil {
+			return rerr
+		}
+		serr := helpers.SyncDir(filepath.Dir(step.From))
+		if serr != nil {
+			return serr
+		}
+		step.Done = false
+		jerr := v.writeJournal(journal)
+		if jerr != nil {
+			return jerr
+		}
+	}
+	return os.Remove(v.GetCommitFlag())
+}
+
+// RecoverCommit finishes or rolls back a commit that was interrupted.
+// A commit is finished if all the new files are still there, otherwise it
+// is rolled back. Returns true if there was an interrupted commit.
+func (v VMImage) RecoverCommit() (bool, error) {
+	if !v.CommitFlagExists() {
+		return false, nil
+	}
+
+	journal, jerr := v.readJournal()
+	if jerr != nil {
+		// Not a journal, there is nothing to recover but the stale flag
+		v.logger().Println("Removing invalid commit flag for '" + v.ImageName + "'...")
+		return true, os.Remove(v.GetCommitFlag())
+	}
+
+	hostname, _ := os.Hostname()
+	if journal.Host == hostname && journal.PID != os.Getpid() && helpers.ProcessAlive(journal.PID) {
+		return false, errors.New("Commit of '" + v.ImageName + "' is in progress by another process")
+	}
+
+	// Journals from before the state file have no state to save, and a
+	// rollback that was started is finished
+	canFinish := journal.NewState != nil && !journal.RollingBack
+	for _, step := range journal.Steps {
+		if !stepDone(step) {
+			_, serr := os.Stat(step.From)
+			if serr != nil {
+				canFinish = false
+				break
+			}
+		}
+	}
+
+	if canFinish {
+		v.logger().Println("Finishing interrupted commit of '" + v.ImageName + "'...")
+		return true, v.applyJournal(journal)
+	}
+	v.logger().Println("Rolling back interrupted commit of '" + v.ImageName + "'...")
+	return true, v.rollbackJournal(journal)
+}
+
+// RecoverCommits runs RecoverCommit on all the images in the path
+func RecoverCommits(path string) {
+	for _, imagePath := range GetAvailableImages(path) {
+		image, ierr := NewVMImage(path, imagePath)
+		if ierr != nil {
+			continue
+		}
+		_, rerr := image.RecoverCommit()
+		if rerr != nil {
+			log.Println("Could not recover commit of '" + imagePath + "': " + rerr.Error())
+		}
+	}
+}
+
+// CommitBuild updates the image files and their state. The new files are
+// hashed first, so cancelling the context leaves the image untouched.
+// The moves are journaled so an interrupted commit can be recovered.
+func (v VMImage) CommitBuild(ctx context.Context) error {
+	artifactPassword, perr := v.readBuildCredentials()
+	if perr != nil {
+		return perr
+	}
+	hardware, herr := v.readBuildHardware()
+	if herr != nil {
+		return herr
+	}
+
+	v.logger().Println("Hashing new image files...")
+
+	newState := ImageState{
+		SchemaVersion: CurrentSchemaVersion,
+		Outputs:       make(map[string]OutputState),
+	}
+	for key, value := range v.State.Outputs {
+		newState.Outputs[key] = value
+	}
+	if hardware != nil {
+		newState.Hardware = hardware
+	} else {
+		newState.Hardware = v.State.Hardware
+	}
+
+	// The outputs of a build share its date, which versions Vagrant boxes
+	commitDate := time.Now().Format("2006-01-02 15:04:05")
+
+	hostname, _ := os.Hostname()
+	journal := commitJournal{
+		PID:      os.Getpid(),
+		Host:     hostname,
+		Started:  time.Now().Format("2006-01-02 15:04:05"),
+		NewState: &newState,
+	}
+
+	for hypervisor, outFileName := range v.GetOutputs() {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		oldImagefilePath := v.ImageRootDir + "/Old-" + outFileName
+		currentImagefilePath := v.ImageRootDir + "/" + outFileName
+		newImagefilePath := v.GetWorkDirPath() + "/" + outFileName
+		backupPath := v.GetWorkDirPath() + "/Old-" + outFileName + ".bak"
+
+		fileHash, err := helpers.GetFileSHA256(newImagefilePath)
+		if err != nil {
+			return err
+		}
+		current := v.State.Outputs[hypervisor]
+		newState.Outputs[hypervisor] = OutputState{
+			CurrentHash: fileHash,
+			CurrentDate: commitDate,
+			LastHash:    current.CurrentHash,
+			LastDate:    current.CurrentDate,
+
+			CurrentPassword: artifactPassword,
+			LastPassword:    current.CurrentPassword,
+		}
+
+		// The old image is set aside until the commit is done, the
+		// existing one becomes the old one, then the new one is moved in
+		_, err = os.Stat(oldImagefilePath)
+		if err == nil {
+			journal.Steps = append(journal.Steps, commitStep{From: oldImagefilePath, To: backupPath})
+			journal.Backups = append(journal.Backups, backupPath)
+		}
+		_, err = os.Stat(currentImagefilePath)
+		if err == nil {
+			journal.Steps = append(journal.Steps, commitStep{From: currentImagefilePath, To: oldImagefilePath})
+		}
+		journal.Steps = append(journal.Steps, commitStep{From: newImagefilePath, To: currentImagefilePath})
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	// Moving the files is quick, so once started it is not interrupted
+	v.logger().Println("Updating state and moving files...")
+	jerr := v.writeJournal(&journal)
+	if jerr != nil {
+		return jerr
+	}
+
+	aerr := v.applyJournal(&journal)
+	if aerr != nil {
+		v.logger().Println("Commit failed, rolling back...")
+		rerr := v.rollbackJournal(&journal)
+		if rerr != nil {
+			return errors.New("Commit failed: " + aerr.Error() + ", rollback failed: " + rerr.Error())
+		}
+		return aerr
+	}
+
+	*v.State = newState
+	return nil
+}
@@ -0,0 +1,95 @@
+package imagemanage
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"testing"
+)
+
+// newCommitTestImage returns an image with current, old and newly built
+// files of one output, and the journal of committing the new one
+func newCommitTestImage(t *testing.T) (VMImage, *commitJournal) {
+	rootDir := t.TempDir()
+	v := VMImage{ImageName: "test", ImageRootDir: rootDir, Logger: log.New(ioutil.Discard, "", 0)}
+	os.Mkdir(v.GetWorkDirPath(), 0777)
+
+	files := map[string]string{
+		rootDir + "/Old-test.box":        "old",
+		rootDir + "/test.box":            "current",
+		v.GetWorkDirPath() + "/test.box": "new",
+	}
+	for path, content := range files {
+		werr := ioutil.WriteFile(path, []byte(content), 0644)
+		if werr != nil {
+			t.Fatal(werr)
+		}
+	}
+
+	backupPath := v.GetWorkDirPath() + "/Old-test.box.bak"
+	journal := &commitJournal{
+		PID: -1,
+		Steps: []commitStep{
+			{From: rootDir + "/Old-test.box", To: backupPath},
+			{From: rootDir + "/test.box", To: rootDir + "/Old-test.box"},
+			{From: v.GetWorkDirPath() + "/test.box", To: rootDir + "/test.box"},
+		},
+		Backups:  []string{backupPath},
+		NewState: &ImageState{SchemaVersion: CurrentSchemaVersion},
+	}
+	return v, journal
+}
+
+func checkFile(t *testing.T, path string, want string) {
+	t.Helper()
+	data, rerr := ioutil.ReadFile(path)
+	if rerr != nil {
+		t.Fatalf("reading %s: %v", path, rerr)
+	}
+	if string(data) != want {
+		t.Errorf("%s has %q, want %q", path, data, want)
+	}
+}
+
+func TestRecoverInterruptedRollback(t *testing.T) {
+	// undone is how many moves the rollback undid before it was
+	// interrupted, without recording them in the journal
+	for undone := 0; undone <= 3; undone++ {
+		t.Run(strconv.Itoa(undone)+" undone", func(t *testing.T) {
+			v, journal := newCommitTestImage(t)
+			for i := range journal.Steps {
+				rerr := os.Rename(journal.Steps[i].From, journal.Steps[i].To)
+				if rerr != nil {
+					t.Fatal(rerr)
+				}
+				journal.Steps[i].Done = true
+			}
+			for i := len(journal.Steps) - 1; i >= len(journal.Steps)-undone; i-- {
+				rerr := os.Rename(journal.Steps[i].To, journal.Steps[i].From)
+				if rerr != nil {
+					t.Fatal(rerr)
+				}
+			}
+			journal.RollingBack = true
+			werr := v.writeJournal(journal)
+			if werr != nil {
+				t.Fatal(werr)
+			}
+
+			recovered, rerr := v.RecoverCommit()
+			if rerr != nil {
+				t.Fatalf("RecoverCommit() error = %v", rerr)
+			}
+			if !recovered {
+				t.Error("RecoverCommit() = false, want true")
+			}
+			checkFile(t, v.ImageRootDir+"/Old-test.box", "old")
+			checkFile(t, v.ImageRootDir+"/test.box", "current")
+			checkFile(t, v.GetWorkDirPath()+"/test.box", "new")
+			if v.CommitFlagExists() {
+				t.Error("journal was not removed")
+			}
+		})
+	}
+}
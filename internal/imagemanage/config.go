@@ -0,0 +1,508 @@
+package imagemanage
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bocajspear1/vmifactory/internal/helpers"
+)
+
+// CurrentSchemaVersion is the version of the image config format
+const CurrentSchemaVersion = 2
+
+// LoginConfig is how Packer logs in to the guest
+type LoginConfig struct {
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	SudoPassword string `json:"sudo_password"`
+	// Rotate sets a new random password in the guest on every build
+	Rotate bool `json:"rotate,omitempty"`
+}
+
+// SSHConfig has the SSH communicator settings
+type SSHConfig struct {
+	// PrivateKeyFile is a private key to log in with, relative to the image directory
+	PrivateKeyFile string `json:"private_key_file"`
+	// Agent logs in with the keys of the running SSH agent
+	Agent bool `json:"agent"`
+	// Port is the guest's SSH port, 22 if zero
+	Port int `json:"port"`
+	// Timeout is how long to wait for SSH to come up, like "30m"
+	Timeout string `json:"timeout"`
+	// HandshakeAttempts is how many handshakes to try before giving up
+	HandshakeAttempts int `json:"handshake_attempts"`
+	// BastionHost is a host to connect through, the bastion settings are used if it is set
+	BastionHost           string `json:"bastion_host"`
+	BastionPort           int    `json:"bastion_port"`
+	BastionUsername       string `json:"bastion_username"`
+	BastionPrivateKeyFile string `json:"bastion_private_key_file"`
+	BastionAgent          bool   `json:"bastion_agent"`
+	// AuthorizedKeys are public keys installed for the login user in every build
+	AuthorizedKeys []string `json:"authorized_keys"`
+}
+
+// WindowsConfig has the settings for Windows guests
+type WindowsConfig struct {
+	// Sysprep generalizes the image when it is shut down
+	Sysprep bool `json:"sysprep"`
+	// ShutdownTimeout is how long the shutdown may take, like "1h"
+	ShutdownTimeout string `json:"shutdown_timeout"`
+	// WinRMPort is the guest's WinRM port, 5985 or 5986 with SSL if zero
+	WinRMPort     int    `json:"winrm_port"`
+	WinRMUseSSL   bool   `json:"winrm_use_ssl"`
+	WinRMInsecure bool   `json:"winrm_insecure"`
+	WinRMTimeout  string `json:"winrm_timeout"`
+}
+
+// AnsibleConfig has the settings for the playbooks in the ansible directory
+type AnsibleConfig struct {
+	// Mode is "remote" to run Ansible on the build host or "local" to run it in the guest
+	Mode string `json:"mode"`
+	// RolesPaths are role directories shared with other images, relative to the image directory
+	RolesPaths []string `json:"roles_paths"`
+	// ExtraArguments are passed to ansible-playbook
+	ExtraArguments []string `json:"extra_arguments"`
+}
+
+// VMwareConfig has the settings for the vmware output
+type VMwareConfig struct {
+	// DiskFormat is the VMDK subformat, monolithicSparse if empty or streamOptimized
+	DiskFormat string `json:"disk_format"`
+}
+
+// FileRule sets the owner and mode of an uploaded file or directory
+type FileRule struct {
+	// Path is the path in the guest
+	Path string `json:"path"`
+	// Owner is "user" or "user:group", root:root if empty
+	Owner string `json:"owner"`
+	// Mode is an octal mode like "0600"
+	Mode string `json:"mode"`
+}
+
+// FilesConfig has the settings for the files directory uploaded to the guest
+type FilesConfig struct {
+	Rules []FileRule `json:"rules"`
+}
+
+// SourceConfig is the image builds start from
+type SourceConfig struct {
+	// Hypervisor selects the builder, see GetBuilderNames
+	Hypervisor string `json:"hypervisor"`
+	// ImageFile is the file name of the source image in the image directory
+	ImageFile string `json:"imagefile"`
+}
+
+// OutputConfig has the output file names for each hypervisor, empty to skip one
+type OutputConfig struct {
+	VBox   string `json:"vbox"`
+	KVM    string `json:"kvm"`
+	HyperV string `json:"hyperv"`
+	VMware string `json:"vmware"`
+	// VagrantVirtualBox and VagrantLibvirt are Vagrant boxes for the providers
+	VagrantVirtualBox string `json:"vagrant_virtualbox"`
+	VagrantLibvirt    string `json:"vagrant_libvirt"`
+}
+
+// OutputNames are the hypervisor keys of OutputConfig
+var OutputNames = []string{"vbox", "kvm", "hyperv", "vmware", "vagrant_virtualbox", "vagrant_libvirt"}
+
+// Get returns the output file name for a hypervisor
+func (o OutputConfig) Get(hypervisor string) string {
+	switch hypervisor {
+	case "vbox":
+		return o.VBox
+	case "kvm":
+		return o.KVM
+	case "hyperv":
+		return o.HyperV
+	case "vmware":
+		return o.VMware
+	case "vagrant_virtualbox":
+		return o.VagrantVirtualBox
+	case "vagrant_libvirt":
+		return o.VagrantLibvirt
+	}
+	return ""
+}
+
+// Set sets the output file name for a hypervisor, returning false for unknown hypervisors
+func (o *OutputConfig) Set(hypervisor string, fileName string) bool {
+	switch hypervisor {
+	case "vbox":
+		o.VBox = fileName
+	case "kvm":
+		o.KVM = fileName
+	case "hyperv":
+		o.HyperV = fileName
+	case "vmware":
+		o.VMware = fileName
+	case "vagrant_virtualbox":
+		o.VagrantVirtualBox = fileName
+	case "vagrant_libvirt":
+		o.VagrantLibvirt = fileName
+	default:
+		return false
+	}
+	return true
+}
+
+// Files returns the output file names that are set, keyed by hypervisor
+func (o OutputConfig) Files() map[string]string {
+	files := make(map[string]string)
+	for _, hypervisor := range OutputNames {
+		fileName := o.Get(hypervisor)
+		if fileName != "" {
+			files[hypervisor] = fileName
+		}
+	}
+	return files
+}
+
+// BuildConfig has the settings for how an image is built
+type BuildConfig struct {
+	// TemplateFormat is the Packer template format, "hcl" or "json"
+	TemplateFormat string `json:"template_format"`
+	// BuildTimeout is the longest a build may take, like "6h"
+	BuildTimeout string `json:"build_timeout"`
+	// Schedule is when the daemon rebuilds the image, see schedule.Parse
+	Schedule string `json:"schedule"`
+}
+
+// BuilderConfig is the user-edited config of an image
+type BuilderConfig struct {
+	SchemaVersion int    `json:"schema_version"`
+	Name          string `json:"name"`
+	Description   string `json:"description"`
+	// GuestOS is "linux" or "windows", empty for linux
+	GuestOS string        `json:"guest_os"`
+	Login   LoginConfig   `json:"login"`
+	SSH     SSHConfig     `json:"ssh"`
+	Windows WindowsConfig `json:"windows"`
+	Ansible AnsibleConfig `json:"ansible"`
+	Files   FilesConfig   `json:"files"`
+	VMware  VMwareConfig  `json:"vmware"`
+	Source  SourceConfig  `json:"source"`
+	Out     OutputConfig  `json:"out"`
+	Build   BuildConfig   `json:"build"`
+	// Env are environment variables for the provisioning scripts
+	Env map[string]string `json:"env"`
+	// Metadata is free-form information about the image
+	Metadata map[string]string `json:"metadata"`
+}
+
+// OutputState is the generated state of one output
+type OutputState struct {
+	CurrentHash string `json:"current_hash"`
+	CurrentDate string `json:"current_date"`
+	LastHash    string `json:"last_hash"`
+	LastDate    string `json:"last_date"`
+	// CurrentPassword and LastPassword are the guest passwords of the
+	// builds if they differ from the config, such as after a rotation
+	CurrentPassword string `json:"current_password,omitempty"`
+	LastPassword    string `json:"last_password,omitempty"`
+}
+
+// HardwareSummary describes the virtual machine of the last build
+type HardwareSummary struct {
+	CPUs     int   `json:"cpus"`
+	MemoryMB int64 `json:"memory_mb"`
+	// DiskSizes are the virtual sizes of the disks in bytes, the boot disk first
+	DiskSizes []int64 `json:"disk_sizes"`
+	NICs      int     `json:"nics"`
+	// Firmware is "bios" or "efi"
+	Firmware string `json:"firmware"`
+	OSType   string `json:"os_type,omitempty"`
+}
+
+// ImageState is the state generated by builds, kept in a sidecar file
+// next to the config so the config is only ever edited by people
+type ImageState struct {
+	SchemaVersion int                    `json:"schema_version"`
+	Outputs       map[string]OutputState `json:"outputs"`
+	// Hardware is known for sources with a hardware description, like OVAs
+	Hardware *HardwareSummary `json:"hardware,omitempty"`
+}
+
+// newImageState returns an empty state
+func newImageState() *ImageState {
+	return &ImageState{
+		SchemaVersion: CurrentSchemaVersion,
+		Outputs:       make(map[string]OutputState),
+	}
+}
+
+// legacyConfig is the version 1 config, with string maps for every section
+// and the build state mixed into the metadata
+type legacyConfig struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Login       map[string]string `json:"login"`
+	Source      map[string]string `json:"source"`
+	Out         map[string]string `json:"out"`
+	Build       map[string]string `json:"build"`
+	Metadata    map[string]string `json:"metadata"`
+}
+
+var legacyStateRegex = regexp.MustCompile(`^(.+)_(current|last)_(hash|date)$`)
+
+// legacyWebKeys were only ever set in memory by vmif-web
+var legacyWebKeys = []string{"in_progress", "image_path_name"}
+
+// convertLegacy converts a version 1 config. Keys that have no place in
+// the current version are an error, so nothing is lost.
+func convertLegacy(legacy *legacyConfig) (*BuilderConfig, *ImageState, error) {
+	config := &BuilderConfig{
+		SchemaVersion: CurrentSchemaVersion,
+		Name:          legacy.Name,
+		Description:   legacy.Description,
+		Metadata:      make(map[string]string),
+	}
+	state := newImageState()
+	unknown := make([]string, 0)
+
+	for key, value := range legacy.Login {
+		switch key {
+		case "username":
+			config.Login.Username = value
+		case "password":
+			config.Login.Password = value
+		case "sudo_password":
+			config.Login.SudoPassword = value
+		default:
+			unknown = append(unknown, "login."+key)
+		}
+	}
+	for key, value := range legacy.Source {
+		switch key {
+		case "hypervisor":
+			config.Source.Hypervisor = value
+		case "imagefile":
+			config.Source.ImageFile = value
+		default:
+			unknown = append(unknown, "source."+key)
+		}
+	}
+	for key, value := range legacy.Out {
+		if !config.Out.Set(key, value) {
+			unknown = append(unknown, "out."+key)
+		}
+	}
+	for key, value := range legacy.Build {
+		switch key {
+		case "template_format":
+			config.Build.TemplateFormat = value
+		case "build_timeout":
+			config.Build.BuildTimeout = value
+		case "schedule":
+			config.Build.Schedule = value
+		default:
+			unknown = append(unknown, "build."+key)
+		}
+	}
+
+	for key, value := range legacy.Metadata {
+		if stringInList(key, legacyWebKeys) {
+			continue
+		}
+		match := legacyStateRegex.FindStringSubmatch(key)
+		if match == nil {
+			config.Metadata[key] = value
+			continue
+		}
+		output := state.Outputs[match[1]]
+		switch match[2] + "_" + match[3] {
+		case "current_hash":
+			output.CurrentHash = value
+		case "current_date":
+			output.CurrentDate = value
+		case "last_hash":
+			output.LastHash = value
+		case "last_date":
+			output.LastDate = value
+		}
+		state.Outputs[match[1]] = output
+	}
+
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, nil, errors.New("Keys " + strings.Join(unknown, ", ") + " have no place in config schema version " + strconv.Itoa(CurrentSchemaVersion))
+	}
+	return config, state, nil
+}
+
+// readConfigVersion returns the schema version of a config file's contents
+func readConfigVersion(configData []byte) (int, error) {
+	var versioned struct {
+		SchemaVersion int `json:"schema_version"`
+	}
+	jerr := json.Unmarshal(configData, &versioned)
+	if jerr != nil {
+		return 0, jerr
+	}
+	// Version 1 configs had no version
+	if versioned.SchemaVersion == 0 {
+		return 1, nil
+	}
+	return versioned.SchemaVersion, nil
+}
+
+// parseJSON loads the config and state. Version 1 configs are converted
+// in memory, the returned version is the one in the file.
+func (v VMImage) parseJSON() (*BuilderConfig, *ImageState, int, error) {
+	configFile, ferr := ioutil.ReadFile(v.GetConfigPath())
+	if ferr != nil {
+		return nil, nil, 0, errors.New("Could not parse config file: File not found")
+	}
+
+	version, verr := readConfigVersion(configFile)
+	if verr != nil {
+		return nil, nil, 0, verr
+	}
+
+	if version == 1 {
+		var legacy legacyConfig
+		jerr := json.Unmarshal(configFile, &legacy)
+		if jerr != nil {
+			return nil, nil, 0, jerr
+		}
+		config, state, cerr := convertLegacy(&legacy)
+		if cerr != nil {
+			return nil, nil, 0, cerr
+		}
+		return config, state, version, nil
+	} else if version > CurrentSchemaVersion {
+		return nil, nil, 0, errors.New("Config schema version " + strconv.Itoa(version) + " is newer than this version of VMIFactory supports")
+	}
+
+	var config BuilderConfig
+	jerr := json.Unmarshal(configFile, &(config))
+	if jerr != nil {
+		return nil, nil, 0, jerr
+	}
+
+	state, serr := v.parseState()
+	if serr != nil {
+		return nil, nil, 0, serr
+	}
+
+	return &config, state, version, nil
+}
+
+// parseState loads the state sidecar file, an empty state if there is none yet
+func (v VMImage) parseState() (*ImageState, error) {
+	stateFile, ferr := ioutil.ReadFile(v.GetStatePath())
+	if os.IsNotExist(ferr) {
+		return newImageState(), nil
+	} else if ferr != nil {
+		return nil, ferr
+	}
+
+	state := newImageState()
+	jerr := json.Unmarshal(stateFile, state)
+	if jerr != nil {
+		return nil, errors.New("Could not parse state file " + v.GetStatePath() + ": " + jerr.Error())
+	}
+	if state.Outputs == nil {
+		state.Outputs = make(map[string]OutputState)
+	}
+	return state, nil
+}
+
+func (v VMImage) saveJSON() error {
+	newConfig, merr := json.MarshalIndent(v.Config, "", "    ")
+	if merr != nil {
+		return merr
+	}
+	err := helpers.WriteFileAtomic(v.GetConfigPath(), newConfig, 0644)
+	return err
+}
+
+func (v VMImage) saveState() error {
+	newState, merr := json.MarshalIndent(v.State, "", "    ")
+	if merr != nil {
+		return merr
+	}
+	return helpers.WriteFileAtomic(v.GetStatePath(), newState, 0644)
+}
+
+// NeedsMigration checks if the config file is an older schema version
+func (v VMImage) NeedsMigration() bool {
+	return v.loadedVersion < CurrentSchemaVersion
+}
+
+// Migrate upgrades the config file to the current schema version, moving
+// the build state to the state file. The old config is kept with a
+// '.v<version>' suffix. Returns false if the config was already current.
+func (v VMImage) Migrate() (bool, error) {
+	if !v.NeedsMigration() {
+		return false, nil
+	}
+
+	backupPath := v.GetConfigPath() + ".v" + strconv.Itoa(v.loadedVersion)
+	copyerr := helpers.CopyFile(v.GetConfigPath(), backupPath)
+	if copyerr != nil {
+		return false, copyerr
+	}
+
+	// The state goes first, a crash before the config is saved just
+	// means the migration is done again
+	serr := v.saveState()
+	if serr != nil {
+		return false, serr
+	}
+	cerr := v.saveJSON()
+	if cerr != nil {
+		return false, cerr
+	}
+	return true, nil
+}
+
+// MigrateForBuild upgrades an older config before a build, so existing
+// images keep building. The caller must hold the image's build lock. The
+// config is read again first, it may have been migrated since it was
+// loaded.
+func (v *VMImage) MigrateForBuild() error {
+	config, state, version, perr := v.parseJSON()
+	if perr != nil {
+		return perr
+	}
+	v.Config = config
+	v.State = state
+	v.loadedVersion = version
+	if !v.NeedsMigration() {
+		return nil
+	}
+
+	v.logger().Println("Migrating config from schema version " + strconv.Itoa(version) + " to " + strconv.Itoa(CurrentSchemaVersion) + "...")
+	_, merr := v.Migrate()
+	if merr != nil {
+		return errors.New("Could not migrate the config of '" + v.ImageName + "': " + merr.Error())
+	}
+	v.loadedVersion = CurrentSchemaVersion
+	return nil
+}
+
+// MigrateImage upgrades an image's config to the current schema version.
+// The image is locked so a running build does not see it change.
+func MigrateImage(path string, imageName string) (bool, error) {
+	image, ierr := NewVMImage(path, imageName)
+	if ierr != nil {
+		return false, ierr
+	}
+	if !image.NeedsMigration() {
+		return false, nil
+	}
+	lock, lerr := image.Lock()
+	if lerr != nil {
+		return false, lerr
+	}
+	defer lock.Unlock()
+	return image.Migrate()
+}
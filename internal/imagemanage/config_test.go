@@ -0,0 +1,66 @@
+package imagemanage
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestMigrateForBuild(t *testing.T) {
+	path := t.TempDir()
+	imageDir := path + "/legacy"
+	os.Mkdir(imageDir, 0777)
+	legacy := `{
+		"name": "Legacy",
+		"login": {"username": "user", "password": "pass", "sudo_password": "pass"},
+		"source": {"hypervisor": "vbox", "imagefile": "legacy.ova"},
+		"out": {"vbox": "legacy.ova"},
+		"metadata": {"owner": "ops", "vbox_current_hash": "abc", "vbox_current_date": "2026-01-02"}
+	}`
+	werr := ioutil.WriteFile(imageDir+"/legacy.json", []byte(legacy), 0644)
+	if werr != nil {
+		t.Fatal(werr)
+	}
+	image, ierr := NewVMImage(path, "legacy")
+	if ierr != nil {
+		t.Fatalf("NewVMImage() error = %v", ierr)
+	}
+	image.Logger = log.New(ioutil.Discard, "", 0)
+	if !image.NeedsMigration() {
+		t.Fatal("NeedsMigration() = false for a version 1 config")
+	}
+
+	lock, lerr := image.Lock()
+	if lerr != nil {
+		t.Fatal(lerr)
+	}
+	defer lock.Unlock()
+	merr := image.MigrateForBuild()
+	if merr != nil {
+		t.Fatalf("MigrateForBuild() error = %v", merr)
+	}
+	if image.NeedsMigration() {
+		t.Error("NeedsMigration() = true after MigrateForBuild()")
+	}
+	checkFile(t, imageDir+"/legacy.json.v1", legacy)
+	if image.State.Outputs["vbox"].CurrentHash != "abc" || image.Config.Metadata["owner"] != "ops" {
+		t.Errorf("migrated state = %+v, metadata = %v", image.State, image.Config.Metadata)
+	}
+
+	// A second build finds the config migrated and keeps the first backup
+	again, _ := NewVMImage(path, "legacy")
+	if again.NeedsMigration() {
+		t.Error("migrated config still needs migration when loaded")
+	}
+	image.loadedVersion = 1
+	merr = image.MigrateForBuild()
+	if merr != nil || image.NeedsMigration() {
+		t.Errorf("MigrateForBuild() of a migrated config = %v", merr)
+	}
+	checkFile(t, imageDir+"/legacy.json.v1", legacy)
+	state, serr := again.parseState()
+	if serr != nil || state.Outputs["vbox"].CurrentHash != "abc" {
+		t.Errorf("state file = %+v, %v, want the migrated hash", state, serr)
+	}
+}
@@ -0,0 +1,206 @@
+package imagemanage
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/bocajspear1/vmifactory/internal/helpers"
+	"github.com/bocajspear1/vmifactory/internal/packer"
+	"github.com/bocajspear1/vmifactory/internal/secrets"
+)
+
+// Template variables holding the guest credentials
+const (
+	varSSHPassword = "ssh_password"
+	// varSudoPassword is the sudo password quoted for the guest shell
+	varSudoPassword = "sudo_password_quoted"
+	// varNewPassword and varNewPasswordQuoted are the rotated password
+	varNewPassword       = "new_password"
+	varNewPasswordQuoted = "new_password_quoted"
+)
+
+// buildVariables are the values of a build's template variables
+type buildVariables struct {
+	values map[string]string
+	// secrets are the secret values in every form they may be output
+	secrets []string
+	// artifactPassword is the guest password of the build's outputs if it
+	// differs from the config
+	artifactPassword string
+}
+
+// buildCredentials are the credentials of a build's outputs, kept in the
+// work directory until the build is committed
+type buildCredentials struct {
+	Password string `json:"password"`
+}
+
+// env returns the variables as Packer environment variables
+func (b buildVariables) env() []string {
+	env := make([]string, 0, len(b.values))
+	for name, value := range b.values {
+		env = append(env, packer.VariableEnv(name)+"="+value)
+	}
+	return env
+}
+
+// shellQuote quotes a string for a POSIX shell
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// sudoCommand returns a guest command run with sudo, feeding it the sudo password
+func sudoCommand(command string) packer.Interpolation {
+	return sudoCommandWith(varSudoPassword, command)
+}
+
+// sudoCommandWith is sudoCommand with the quoted password in another variable
+func sudoCommandWith(passwordVar string, command string) packer.Interpolation {
+	return packer.Interpolation{"printf '%s\\n' ", packer.VarRef(passwordVar), " | sudo -p '' -S " + command}
+}
+
+// addCredentialVariables declares the credential variables in the template
+func (v VMImage) addCredentialVariables(template *packer.Template) {
+	template.AddVariable(packer.Variable{Name: varSSHPassword, Sensitive: true})
+	// Windows logins are administrators and need no sudo
+	if !v.IsWindows() {
+		template.AddVariable(packer.Variable{Name: varSudoPassword, Sensitive: true})
+	}
+	if v.Config.Login.Rotate {
+		template.AddVariable(packer.Variable{Name: varNewPassword, Sensitive: true})
+		template.AddVariable(packer.Variable{Name: varNewPasswordQuoted, Sensitive: true})
+	}
+}
+
+// shutdownCommand returns the guest shutdown command. After a rotation
+// sudo wants the new password.
+func (v VMImage) shutdownCommand() packer.Interpolation {
+	if v.Config.Login.Rotate {
+		return sudoCommandWith(varNewPasswordQuoted, "poweroff")
+	}
+	return sudoCommand("poweroff")
+}
+
+// rotationProvisioner returns the provisioner that sets the rotated
+// password, which has to be the last one
+func (v VMImage) rotationProvisioner() packer.Provisioner {
+	return packer.Provisioner{
+		Type: "shell",
+		Settings: packer.Settings{
+			"environment_vars": []interface{}{
+				"VMIF_USERNAME=" + v.Config.Login.Username,
+				packer.Interpolation{"VMIF_NEW_PASSWORD=", packer.VarRef(varNewPassword)},
+			},
+			"inline":          []string{`printf '%s:%s\n' "$VMIF_USERNAME" "$VMIF_NEW_PASSWORD" | chpasswd`},
+			"execute_command": sudoCommand("env {{ .Vars }} {{ .Path }}"),
+		},
+	}
+}
+
+const passwordCharacters = "ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz23456789"
+
+// randomPassword returns a random password that is easy to type
+func randomPassword(length int) (string, error) {
+	var str strings.Builder
+	max := big.NewInt(int64(len(passwordCharacters)))
+	for i := 0; i < length; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		str.WriteByte(passwordCharacters[n.Int64()])
+	}
+	return str.String(), nil
+}
+
+// resolveVariables resolves the secrets of the config into the values
+// of the build's template variables. An image whose password was rotated
+// is logged in to with the password of its last build.
+func (v VMImage) resolveVariables() (*buildVariables, error) {
+	var password, sudoPassword string
+	currentPassword := v.State.Outputs[v.Config.Source.Hypervisor].CurrentPassword
+	if currentPassword != "" {
+		// Rotation requires sudo to use the user's own password
+		password = currentPassword
+		sudoPassword = currentPassword
+	} else {
+		var perr, serr error
+		password, perr = secrets.Resolve(v.Config.Login.Password)
+		if perr != nil {
+			return nil, errors.New("Could not resolve 'login'/'password': " + perr.Error())
+		}
+		sudoPassword, serr = secrets.Resolve(v.Config.Login.SudoPassword)
+		if serr != nil {
+			return nil, errors.New("Could not resolve 'login'/'sudo_password': " + serr.Error())
+		}
+	}
+
+	quotedSudoPassword := shellQuote(sudoPassword)
+	variables := &buildVariables{
+		values: map[string]string{
+			varSSHPassword:  password,
+			varSudoPassword: quotedSudoPassword,
+		},
+		secrets:          []string{password, sudoPassword, quotedSudoPassword},
+		artifactPassword: currentPassword,
+	}
+
+	if v.HasAnsible() {
+		becomeVars, berr := ansibleBecomeVars(sudoPassword)
+		if berr != nil {
+			return nil, berr
+		}
+		variables.values[varBecomeVars] = becomeVars
+		variables.secrets = append(variables.secrets, becomeVars)
+	}
+
+	if v.Config.Login.Rotate {
+		newPassword, rerr := randomPassword(20)
+		if rerr != nil {
+			return nil, rerr
+		}
+		variables.values[varNewPassword] = newPassword
+		variables.values[varNewPasswordQuoted] = shellQuote(newPassword)
+		variables.secrets = append(variables.secrets, newPassword, shellQuote(newPassword))
+		variables.artifactPassword = newPassword
+	}
+	return variables, nil
+}
+
+// getCredentialsPath returns the path of the build's credentials
+func (v VMImage) getCredentialsPath() string {
+	return v.GetWorkDirPath() + "/credentials.json"
+}
+
+// saveBuildCredentials keeps the build's guest password until it is committed
+func (v VMImage) saveBuildCredentials(password string) error {
+	if password == "" {
+		return nil
+	}
+	data, merr := json.Marshal(buildCredentials{Password: password})
+	if merr != nil {
+		return merr
+	}
+	return helpers.WriteFileAtomic(v.getCredentialsPath(), data, 0600)
+}
+
+// readBuildCredentials reads the build's guest password, empty if it is the config's
+func (v VMImage) readBuildCredentials() (string, error) {
+	data, ferr := ioutil.ReadFile(v.getCredentialsPath())
+	if os.IsNotExist(ferr) {
+		return "", nil
+	} else if ferr != nil {
+		return "", ferr
+	}
+	var credentials buildCredentials
+	jerr := json.Unmarshal(data, &credentials)
+	if jerr != nil {
+		return "", errors.New("Could not parse build credentials: " + jerr.Error())
+	}
+	return credentials.Password, nil
+}
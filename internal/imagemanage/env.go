@@ -0,0 +1,69 @@
+package imagemanage
+
+import (
+	"sort"
+	"time"
+
+	"github.com/bocajspear1/vmifactory/internal/settings"
+)
+
+// buildInfo identifies a build to its provisioning scripts
+type buildInfo struct {
+	ID   string
+	Date string
+}
+
+// newBuildInfo returns the info of a build starting now
+func (v VMImage) newBuildInfo() buildInfo {
+	now := time.Now().UTC()
+	return buildInfo{
+		ID:   v.ImageName + "-" + now.Format("20060102-150405"),
+		Date: now.Format(time.RFC3339),
+	}
+}
+
+// scriptEnv returns the environment variables of the provisioning
+// scripts as NAME=value, sorted by name. The image's 'env' wins over the
+// global 'env' setting, which wins over the proxy settings. The VMIF_
+// variables are always set by VMIFactory.
+func (v VMImage) scriptEnv(info buildInfo) []string {
+	env := make(map[string]string)
+
+	proxy := settings.Get().Proxy
+	proxyVars := [][3]string{
+		{"http_proxy", "HTTP_PROXY", proxy.HTTP},
+		{"https_proxy", "HTTPS_PROXY", proxy.HTTPS},
+		{"no_proxy", "NO_PROXY", proxy.NoProxy},
+	}
+	// Tools disagree on the case, so both are set
+	for _, proxyVar := range proxyVars {
+		if proxyVar[2] != "" {
+			env[proxyVar[0]] = proxyVar[2]
+			env[proxyVar[1]] = proxyVar[2]
+		}
+	}
+
+	for name, value := range settings.Get().Env {
+		env[name] = value
+	}
+	for name, value := range v.Config.Env {
+		env[name] = value
+	}
+
+	env["VMIF_IMAGE_NAME"] = v.ImageName
+	env["VMIF_BUILD_ID"] = info.ID
+	env["VMIF_BUILD_DATE"] = info.Date
+	env["VMIF_PREVIOUS_HASH"] = v.State.Outputs[v.Config.Source.Hypervisor].CurrentHash
+
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	envList := make([]string, 0, len(names))
+	for _, name := range names {
+		envList = append(envList, name+"="+env[name])
+	}
+	return envList
+}
@@ -0,0 +1,165 @@
+package imagemanage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bocajspear1/vmifactory/internal/packer"
+)
+
+// filesStagingPath is where the files are uploaded to before they are installed
+const filesStagingPath = "/tmp/vmifactory-files"
+
+// Defaults for uploaded files without a rule
+const (
+	defaultFileOwner = "root:root"
+	defaultFileMode  = "0644"
+	defaultExecMode  = "0755"
+	defaultDirMode   = "0755"
+)
+
+var fileOwnerRegex = regexp.MustCompile(`^[a-z_][a-z0-9_.-]*(:[a-z_][a-z0-9_.-]*)?$`)
+var fileModeRegex = regexp.MustCompile(`^0?[0-7]{3,4}$`)
+
+// guestFile is a file or directory of the files directory
+type guestFile struct {
+	// HostPath is the absolute path of the file on the build host
+	HostPath string
+	// GuestPath is where the file goes in the guest
+	GuestPath string
+	IsDir     bool
+	// Executable is if the file is executable on the build host
+	Executable bool
+}
+
+// GetFilesPath returns the path to the directory mirrored into the guest
+func (v VMImage) GetFilesPath() string {
+	return v.ImageRootDir + "/files"
+}
+
+// listGuestFiles returns the files and directories of the files
+// directory, parents before their contents. There are none if the
+// directory does not exist.
+func (v VMImage) listGuestFiles() ([]guestFile, error) {
+	filesPath, _ := filepath.Abs(v.GetFilesPath())
+	if _, serr := os.Stat(filesPath); os.IsNotExist(serr) {
+		return nil, nil
+	}
+
+	files := make([]guestFile, 0)
+	werr := filepath.Walk(filesPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == filesPath {
+			return nil
+		}
+		relPath, _ := filepath.Rel(filesPath, path)
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return errors.New("Uploaded file files/" + relPath + " must be a regular file or directory")
+		}
+		for _, part := range strings.Split(filepath.ToSlash(relPath), "/") {
+			if !legalFileName(part) {
+				return errors.New("Uploaded file files/" + relPath + " has an illegal name")
+			}
+		}
+		files = append(files, guestFile{
+			HostPath:   path,
+			GuestPath:  "/" + filepath.ToSlash(relPath),
+			IsDir:      info.IsDir(),
+			Executable: info.Mode()&0111 != 0,
+		})
+		return nil
+	})
+	if werr != nil {
+		return nil, werr
+	}
+	return files, nil
+}
+
+// fileRule returns the rule for a guest path, the last one if several match
+func (v VMImage) fileRule(guestPath string) (FileRule, bool) {
+	var found FileRule
+	ok := false
+	for _, rule := range v.Config.Files.Rules {
+		if strings.TrimSuffix(rule.Path, "/") == guestPath {
+			found = rule
+			ok = true
+		}
+	}
+	return found, ok
+}
+
+// filesProvisioners returns the provisioners that upload the files
+// directory to a staging directory and install them with sudo, since
+// the login user cannot write everywhere
+func (v VMImage) filesProvisioners() ([]packer.Provisioner, error) {
+	files, lerr := v.listGuestFiles()
+	if lerr != nil {
+		return nil, lerr
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	filesPath, _ := filepath.Abs(v.GetFilesPath())
+	staging := shellQuote(filesStagingPath)
+
+	commands := make([]string, 0, len(files)*3+1)
+	for _, file := range files {
+		dest := shellQuote(file.GuestPath)
+		rule, hasRule := v.fileRule(file.GuestPath)
+		if file.IsDir {
+			// Existing directories like /etc keep their owner and mode unless a rule says otherwise
+			commands = append(commands, "[ -d "+dest+" ] || install -d -m "+defaultDirMode+" "+dest)
+			if !hasRule {
+				continue
+			}
+		} else {
+			commands = append(commands, "cp "+shellQuote(filesStagingPath+file.GuestPath)+" "+dest)
+		}
+
+		owner := defaultFileOwner
+		mode := defaultFileMode
+		if file.IsDir {
+			mode = defaultDirMode
+		} else if file.Executable {
+			mode = defaultExecMode
+		}
+		if hasRule && rule.Owner != "" {
+			owner = rule.Owner
+		}
+		if hasRule && rule.Mode != "" {
+			mode = rule.Mode
+		}
+		commands = append(commands, "chown "+shellQuote(owner)+" "+dest, "chmod "+mode+" "+dest)
+	}
+	commands = append(commands, "rm -rf "+staging)
+
+	return []packer.Provisioner{
+		{
+			Type: "shell",
+			Settings: packer.Settings{
+				"inline": []string{"rm -rf " + staging, "mkdir -m 700 " + staging},
+			},
+		},
+		{
+			Type: "file",
+			Settings: packer.Settings{
+				// The trailing slash uploads the contents instead of the directory
+				"source":      filesPath + "/",
+				"destination": filesStagingPath,
+			},
+		},
+		{
+			Type: "shell",
+			Settings: packer.Settings{
+				"inline":          commands,
+				"execute_command": sudoCommand("env {{ .Vars }} {{ .Path }}"),
+			},
+		},
+	}, nil
+}
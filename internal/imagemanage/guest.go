@@ -0,0 +1,175 @@
+package imagemanage
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+
+	"github.com/bocajspear1/vmifactory/internal/packer"
+)
+
+// Guest operating systems
+const (
+	GuestLinux   = "linux"
+	GuestWindows = "windows"
+)
+
+// restartMarker is the extension of empty files in run/ and runonce/ that
+// restart a Windows guest at that point
+const restartMarker = ".restart"
+
+// sysprepCommand generalizes a Windows guest and shuts it down
+const sysprepCommand = `C:\Windows\System32\Sysprep\sysprep.exe /generalize /oobe /shutdown /quiet`
+
+// windowsShutdownCommand shuts down a Windows guest
+const windowsShutdownCommand = `shutdown /s /t 10 /f /d p:4:1 /c "Packer Shutdown"`
+
+// GetGuestOS returns the guest operating system, set by the 'guest_os' config key
+func (v VMImage) GetGuestOS() string {
+	if v.Config.GuestOS == "" {
+		return GuestLinux
+	}
+	return v.Config.GuestOS
+}
+
+// IsWindows checks if the guest is Windows
+func (v VMImage) IsWindows() bool {
+	return v.GetGuestOS() == GuestWindows
+}
+
+// addCommunicatorSettings adds the login settings to the source, SSH for
+// Linux guests and WinRM for Windows guests. The passwords are variables
+// so they stay out of the template.
+func (v VMImage) addCommunicatorSettings(settings packer.Settings) {
+	if !v.IsWindows() {
+		settings["ssh_username"] = v.Config.Login.Username
+		settings["ssh_password"] = packer.VarRef(varSSHPassword)
+		v.addSSHSettings(settings)
+		return
+	}
+
+	windows := v.Config.Windows
+	settings["communicator"] = "winrm"
+	settings["winrm_username"] = v.Config.Login.Username
+	settings["winrm_password"] = packer.VarRef(varSSHPassword)
+	if windows.WinRMPort != 0 {
+		settings["winrm_port"] = windows.WinRMPort
+	}
+	if windows.WinRMUseSSL {
+		settings["winrm_use_ssl"] = true
+	}
+	if windows.WinRMInsecure {
+		settings["winrm_insecure"] = true
+	}
+	if windows.WinRMTimeout != "" {
+		settings["winrm_timeout"] = windows.WinRMTimeout
+	}
+}
+
+// addShutdownSettings adds the command that shuts the guest down at the
+// end of the build. Windows guests are generalized with sysprep if set.
+func (v VMImage) addShutdownSettings(settings packer.Settings) {
+	if !v.IsWindows() {
+		settings["shutdown_command"] = v.shutdownCommand()
+		return
+	}
+
+	shutdownTimeout := v.Config.Windows.ShutdownTimeout
+	if v.Config.Windows.Sysprep {
+		settings["shutdown_command"] = sysprepCommand
+		// Sysprep takes far longer than the default five minutes
+		if shutdownTimeout == "" {
+			shutdownTimeout = "1h"
+		}
+	} else {
+		settings["shutdown_command"] = windowsShutdownCommand
+	}
+	if shutdownTimeout != "" {
+		settings["shutdown_timeout"] = shutdownTimeout
+	}
+}
+
+// scriptProvisionerType returns the provisioner that runs a script file,
+// empty if the guest cannot run it
+func (v VMImage) scriptProvisionerType(fileName string) string {
+	lowerName := strings.ToLower(fileName)
+	if !v.IsWindows() {
+		if strings.HasSuffix(lowerName, restartMarker) {
+			return ""
+		}
+		return "shell"
+	}
+	switch {
+	case strings.HasSuffix(lowerName, ".ps1"):
+		return "powershell"
+	case strings.HasSuffix(lowerName, ".bat"), strings.HasSuffix(lowerName, ".cmd"):
+		return "windows-shell"
+	case strings.HasSuffix(lowerName, restartMarker):
+		return "windows-restart"
+	}
+	return ""
+}
+
+// listScripts returns the paths of the scripts to run, the runonce
+// scripts first, each directory in alphabetical order
+func (v VMImage) listScripts() ([]string, error) {
+	runOnceScripts, err := ioutil.ReadDir(v.GetRunOncePath())
+	if err != nil {
+		return nil, errors.New("Could not list the runOnce directory for the image")
+	}
+
+	runScripts, err := ioutil.ReadDir(v.GetRunPath())
+	if err != nil {
+		return nil, errors.New("Could not list the run directory for the image")
+	}
+
+	allScripts := make([]string, 0, len(runOnceScripts)+len(runScripts))
+	for _, script := range runOnceScripts {
+		if !script.IsDir() {
+			allScripts = append(allScripts, v.GetRunOncePath()+"/"+script.Name())
+		}
+	}
+	for _, script := range runScripts {
+		if !script.IsDir() {
+			allScripts = append(allScripts, v.GetRunPath()+"/"+script.Name())
+		}
+	}
+	return allScripts, nil
+}
+
+// scriptProvisioners returns the provisioners running the scripts in
+// order with the environment variables in env. Consecutive scripts of
+// the same type share a provisioner.
+func (v VMImage) scriptProvisioners(env []string) ([]packer.Provisioner, error) {
+	allScripts, lerr := v.listScripts()
+	if lerr != nil {
+		return nil, lerr
+	}
+
+	provisioners := make([]packer.Provisioner, 0)
+	var current *packer.Provisioner
+	for _, script := range allScripts {
+		provisionerType := v.scriptProvisionerType(script)
+		if provisionerType == "" {
+			return nil, errors.New("Script " + script + " cannot be run on a " + v.GetGuestOS() + " guest")
+		}
+
+		if provisionerType == "windows-restart" {
+			provisioners = append(provisioners, packer.Provisioner{Type: provisionerType, Settings: packer.Settings{}})
+			current = nil
+			continue
+		}
+
+		if current == nil || current.Type != provisionerType {
+			settings := packer.Settings{"scripts": []string{}, "environment_vars": env}
+			// Linux scripts run as root, Windows logins are administrators
+			if provisionerType == "shell" {
+				settings["execute_command"] = sudoCommand("env {{ .Vars }} {{ .Path }}")
+			}
+			provisioners = append(provisioners, packer.Provisioner{Type: provisionerType, Settings: settings})
+			current = &provisioners[len(provisioners)-1]
+		}
+		current.Settings["scripts"] = append(current.Settings["scripts"].([]string), script)
+	}
+	return provisioners, nil
+}
@@ -0,0 +1,57 @@
+package imagemanage
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+
+	"github.com/bocajspear1/vmifactory/internal/converters"
+	"github.com/bocajspear1/vmifactory/internal/helpers"
+)
+
+// newHardwareSummary summarizes the hardware of an OVF descriptor
+func newHardwareSummary(hardware *converters.OVFHardware) *HardwareSummary {
+	summary := &HardwareSummary{
+		CPUs:      hardware.CPUs,
+		MemoryMB:  hardware.MemoryMB,
+		DiskSizes: make([]int64, len(hardware.Disks)),
+		NICs:      len(hardware.NICTypes),
+		Firmware:  hardware.Firmware,
+		OSType:    hardware.OSType,
+	}
+	for i, disk := range hardware.Disks {
+		summary.DiskSizes[i] = disk.CapacityBytes
+	}
+	return summary
+}
+
+// getHardwarePath returns the path of the build's hardware summary
+func (v VMImage) getHardwarePath() string {
+	return v.GetWorkDirPath() + "/hardware.json"
+}
+
+// saveBuildHardware keeps the build's hardware summary until it is committed
+func (v VMImage) saveBuildHardware(hardware *converters.OVFHardware) error {
+	data, merr := json.Marshal(newHardwareSummary(hardware))
+	if merr != nil {
+		return merr
+	}
+	return helpers.WriteFileAtomic(v.getHardwarePath(), data, 0644)
+}
+
+// readBuildHardware reads the build's hardware summary, nil if the source has none
+func (v VMImage) readBuildHardware() (*HardwareSummary, error) {
+	data, ferr := ioutil.ReadFile(v.getHardwarePath())
+	if os.IsNotExist(ferr) {
+		return nil, nil
+	} else if ferr != nil {
+		return nil, ferr
+	}
+	summary := new(HardwareSummary)
+	jerr := json.Unmarshal(data, summary)
+	if jerr != nil {
+		return nil, errors.New("Could not parse build hardware: " + jerr.Error())
+	}
+	return summary, nil
+}
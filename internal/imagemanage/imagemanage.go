@@ -1,19 +1,22 @@
 package imagemanage
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"strings"
+	"strconv"
 	"time"
 
 	"github.com/bocajspear1/vmifactory/internal/converters"
 	"github.com/bocajspear1/vmifactory/internal/helpers"
+	"github.com/bocajspear1/vmifactory/internal/packer"
+	"github.com/bocajspear1/vmifactory/internal/schedule"
+	"github.com/bocajspear1/vmifactory/internal/secrets"
+	"github.com/bocajspear1/vmifactory/internal/settings"
 )
 
 // GetAvailableImages returns a list of images
@@ -35,141 +38,128 @@ func GetAvailableImages(path string) []string {
 	return images
 }
 
-type BuilderConfig struct {
-	Name        string            `json:"name"`
-	Description string            `json:"description"`
-	Login       map[string]string `json:"login"`
-	Source      map[string]string `json:"source"`
-	Out         map[string]string `json:"out"`
-	Metadata    map[string]string `json:"metadata"`
-}
-
 // VMImage represents an image and its config.
 type VMImage struct {
 	ImageName    string
 	ImageRootDir string
 	Config       *BuilderConfig
+	// State is the generated build state, kept in the state file
+	State *ImageState
+	// EventHandler is called with the progress events of Packer builds, may be nil
+	EventHandler func(packer.Event)
+	// Logger is used for the image's build output, the standard logger if nil
+	Logger *log.Logger
+	// loadedVersion is the schema version of the config file when it was loaded
+	loadedVersion int
+	// artifactIsSource is set when the build artifact is a copy of the
+	// staged source image, as in test builds
+	artifactIsSource bool
 }
 
-// Private functions for VMImage
-
-func (v VMImage) parseJSON() (*BuilderConfig, error) {
-	configFile, ferr := ioutil.ReadFile(v.GetConfigPath())
-	if ferr != nil {
-		return nil, errors.New("Could not parse config file: File not found")
-	}
-
-	var config BuilderConfig
-	jerr := json.Unmarshal(configFile, &(config))
-	if jerr != nil {
-		return nil, jerr
+// logger returns the logger for the image's build output
+func (v VMImage) logger() *log.Logger {
+	if v.Logger == nil {
+		return log.Default()
 	}
-
-	return &config, nil
-}
-
-func (v VMImage) saveJSON() error {
-	newConfig, merr := json.MarshalIndent(v.Config, "", "    ")
-	if merr != nil {
-		return merr
-	}
-	err := ioutil.WriteFile(v.GetConfigPath(), newConfig, 0644)
-	return err
+	return v.Logger
 }
 
 // Generate the config
-func (v VMImage) generatePackerConfig() (string, error) {
-
-	var str strings.Builder
+func (v VMImage) generatePackerConfig(info buildInfo) (*packer.Template, error) {
 
-	builderString, ok := v.Config.Source["hypervisor"]
-
-	if !ok {
-		return "", errors.New("Required key 'source'/'hypervisor' not found")
+	builder, berr := v.getBuilder()
+	if berr != nil {
+		return nil, berr
 	}
 
-	builderOut := make(map[string]interface{})
-
-	if builderString == "vbox" {
-		builderOut["type"] = "virtualbox-ovf"
-		builderOut["guest_additions_mode"] = "attach"
-		builderOut["format"] = "ova"
-		builderOut["headless"] = true
-		builderOut["source_path"], _ = filepath.Abs(v.GetWorkDirPath() + "/original.ova")
-	} else {
-		return "", errors.New("Builder is not supported")
+	source, berr := builder.PackerBuilder(v)
+	if berr != nil {
+		return nil, berr
 	}
 
-	builderOut["output_directory"], _ = filepath.Abs(v.GetWorkDirPath() + "/packer-out")
-
-	// Login creds
-	// TODO: check
-	builderOut["ssh_username"] = v.Config.Login["username"]
-	builderOut["ssh_password"] = v.Config.Login["password"]
+	source.Settings["output_directory"], _ = filepath.Abs(v.GetWorkDirPath() + "/packer-out")
 
-	builderOut["vm_name"] = v.ImageName + "-vmifactory"
+	v.addCommunicatorSettings(source.Settings)
 
-	// TODO: check
-	str.Reset()
-	str.WriteString("echo '")
-	// TODO: check
-	str.WriteString(v.Config.Login["sudo_password"])
-	str.WriteString("' | sudo -p '' -S poweroff")
+	source.Settings["vm_name"] = v.ImageName + "-vmifactory"
 
-	builderOut["shutdown_command"] = str.String()
+	v.addShutdownSettings(source.Settings)
 
-	fullConfig := make(map[string][]interface{})
+	template := new(packer.Template)
+	template.AddPlugin(builder.PackerPlugin())
+	v.addCredentialVariables(template)
+	template.Sources = append(template.Sources, source)
 
-	fullConfig["builders"] = append(fullConfig["builders"], builderOut)
+	// Files are uploaded first so the scripts can use them
+	filesProvisioners, ferr := v.filesProvisioners()
+	if ferr != nil {
+		return nil, ferr
+	}
+	template.Provisioners = append(template.Provisioners, filesProvisioners...)
 
 	// Add the scripts
-
-	provisionersOut := make(map[string]interface{})
-
-	runScripts, err := ioutil.ReadDir(v.GetRunPath())
-	if err != nil {
-		return "", errors.New("Could not list the run directory for the image")
+	scriptProvisioners, serr := v.scriptProvisioners(v.scriptEnv(info))
+	if serr != nil {
+		return nil, serr
 	}
+	template.Provisioners = append(template.Provisioners, scriptProvisioners...)
 
-	runOnceScripts, err := ioutil.ReadDir(v.GetRunOncePath())
-	if err != nil {
-		return "", errors.New("Could not list the runOnce directory for the image")
+	// Playbooks run after the scripts, which may have to install Python first
+	ansibleProvisioners, aerr := v.ansibleProvisioners(template)
+	if aerr != nil {
+		return nil, aerr
 	}
+	template.Provisioners = append(template.Provisioners, ansibleProvisioners...)
 
-	runOnceCount := len(runOnceScripts) - 1
-	runOnceFull := make([]string, runOnceCount)
-
-	for i := 0; i < len(runOnceScripts); i++ {
-		if !(runOnceScripts[i].IsDir()) {
-			runOnceFull[i] = v.GetRunOncePath() + "/" + runOnceScripts[i].Name()
-		}
+	if len(v.Config.SSH.AuthorizedKeys) > 0 {
+		template.Provisioners = append(template.Provisioners, v.authorizedKeysProvisioner())
 	}
 
-	runFull := make([]string, len(runScripts))
-
-	for i := 0; i < len(runScripts); i++ {
-		runFull[i] = v.GetRunPath() + "/" + runScripts[i].Name()
+	// Changing the password has to come after everything that logs in
+	if v.Config.Login.Rotate {
+		template.Provisioners = append(template.Provisioners, v.rotationProvisioner())
 	}
 
-	allScripts := append(runOnceFull, runFull...)
-
-	provisionersOut["type"] = "shell"
-	provisionersOut["scripts"] = allScripts
-
-	str.Reset()
-	str.WriteString("echo '")
-	// TODO: check
-	str.WriteString(v.Config.Login["sudo_password"])
-	str.WriteString("' | sudo -p '' -S env {{ .Vars }} {{ .Path }}")
+	return template, nil
+}
 
-	provisionersOut["execute_command"] = str.String()
+// GetTemplateFormat returns the format the Packer template is written in,
+// set by the 'build'/'template_format' config key
+func (v VMImage) GetTemplateFormat() string {
+	format := v.Config.Build.TemplateFormat
+	if format == "" {
+		return packer.FormatHCL
+	}
+	return format
+}
 
-	fullConfig["provisioners"] = append(fullConfig["provisioners"], provisionersOut)
+// GetVMDKFormat returns the VMDK subformat of the vmware output, set by
+// the 'vmware'/'disk_format' config key
+func (v VMImage) GetVMDKFormat() string {
+	if v.Config.VMware.DiskFormat == "" {
+		return converters.VMDKMonolithicSparse
+	}
+	return v.Config.VMware.DiskFormat
+}
 
-	// Output the full Packer configurationj
-	outJSONBytes, oerr := json.MarshalIndent(fullConfig, "", "    ")
+// vagrantGuest returns how Vagrant logs in to boxes of the image. Only a
+// plain password that is not rotated goes in the box, secrets stay out.
+func (v VMImage) vagrantGuest() converters.VagrantGuest {
+	guest := converters.VagrantGuest{Username: v.Config.Login.Username, Windows: v.IsWindows()}
+	if !v.Config.Login.Rotate && !secrets.IsReference(v.Config.Login.Password) {
+		guest.Password = v.Config.Login.Password
+	}
+	return guest
+}
 
-	return string(outJSONBytes), oerr
+// GetOutputs returns the output file names of a build keyed by hypervisor.
+// The source image is always an output, it is rebuilt in place.
+func (v VMImage) GetOutputs() map[string]string {
+	outputs := v.Config.Out.Files()
+	if v.Config.Source.Hypervisor != "" && v.Config.Source.ImageFile != "" {
+		outputs[v.Config.Source.Hypervisor] = v.Config.Source.ImageFile
+	}
+	return outputs
 }
 
 // NewVMImage creates new vmimage structs
@@ -179,12 +169,13 @@ func NewVMImage(path string, imageName string) (*VMImage, error) {
 	p.ImageName = imageName
 	p.ImageRootDir = path + "/" + imageName
 
-	config, cerr := p.parseJSON()
-	p.Config = config
-
+	config, state, version, cerr := p.parseJSON()
 	if cerr != nil {
 		return nil, cerr
 	}
+	p.Config = config
+	p.State = state
+	p.loadedVersion = version
 
 	return p, nil
 }
@@ -200,8 +191,18 @@ func (v VMImage) GetConfigPath() string {
 	return v.ImageRootDir + "/" + v.ImageName + ".json"
 }
 
-// GetWorkDirPath returns the path to the work directory
+// GetStatePath returns the path to the state file
+func (v VMImage) GetStatePath() string {
+	return v.ImageRootDir + "/" + v.ImageName + ".state.json"
+}
+
+// GetWorkDirPath returns the path to the work directory, in the image
+// directory unless the 'work_dir' setting is set
 func (v VMImage) GetWorkDirPath() string {
+	workRoot := settings.Get().WorkDir
+	if workRoot != "" {
+		return workRoot + "/" + v.ImageName
+	}
 	return v.ImageRootDir + "/work"
 }
 
@@ -215,6 +216,36 @@ func (v VMImage) GetRunOncePath() string {
 	return v.ImageRootDir + "/runonce"
 }
 
+// moveRunOnce moves the files of a runonce directory that match into its
+// used directory, returning if there were any
+func moveRunOnce(dirPath string, match func(string) bool) (bool, error) {
+	listing, err := ioutil.ReadDir(dirPath)
+	if err != nil {
+		return false, err
+	}
+
+	moved := false
+	for _, item := range listing {
+		if item.IsDir() || (match != nil && !match(item.Name())) {
+			continue
+		}
+		merr := os.MkdirAll(dirPath+"/used", 0777)
+		if merr != nil {
+			return moved, merr
+		}
+		oldPath := dirPath + "/" + item.Name()
+		dt := time.Now()
+		newPath := dirPath + "/used/" + dt.Format("2006-01-02-15.04.05") + item.Name()
+		// A runonce file left in place would run again on the next build
+		rerr := os.Rename(oldPath, newPath)
+		if rerr != nil {
+			return moved, errors.New("Could not move used runonce file " + oldPath + ": " + rerr.Error())
+		}
+		moved = true
+	}
+	return moved, nil
+}
+
 // GetCommitFlag returns the path to commit flag
 func (v VMImage) GetCommitFlag() string {
 	return v.GetWorkDirPath() + "/commit"
@@ -246,218 +277,237 @@ func (v VMImage) DisableCommitFlag() bool {
 }
 
 // PrepareBuild prepares the image for an update build
-func (v VMImage) PrepareBuild() bool {
+func (v VMImage) PrepareBuild(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	// Find config problems now instead of hours into the build
+	problems := v.Validate()
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			v.logger().Println(problem.Error())
+		}
+		return errors.New("Image '" + v.ImageName + "' has " + strconv.Itoa(len(problems)) + " config problem(s), see 'vmif-run validate'")
+	}
+
+	// An interrupted commit has to be sorted out before its files are removed
+	_, rerr := v.RecoverCommit()
+	if rerr != nil {
+		return rerr
+	}
+
 	workDir := v.GetWorkDirPath()
 	_, err := os.Stat(workDir)
 
 	// Remove the old work directory
 	if err == nil {
-		log.Println("Removing old work directory...")
-		os.RemoveAll(workDir)
+		v.logger().Println("Removing old work directory...")
+		rerr := os.RemoveAll(workDir)
+		if rerr != nil {
+			return rerr
+		}
 	}
 
-	os.Mkdir(workDir, 0777)
+	return os.MkdirAll(workDir, 0777)
+}
 
-	return true
+// GetBuildTimeout returns the longest time a build may take, set by the
+// 'build'/'build_timeout' config key. Zero means there is no limit.
+func (v VMImage) GetBuildTimeout() (time.Duration, error) {
+	timeoutString := v.Config.Build.BuildTimeout
+	if timeoutString == "" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(timeoutString)
+	if err != nil {
+		return 0, errors.New("Invalid 'build'/'build_timeout' value '" + timeoutString + "'")
+	}
+	return timeout, nil
+}
+
+// GetSchedule returns when the image is rebuilt in daemon mode, set by
+// the 'build'/'schedule' config key. The schedule is nil if it has none.
+func (v VMImage) GetSchedule() (string, schedule.Schedule, error) {
+	spec := v.Config.Build.Schedule
+	if spec == "" {
+		return "", nil, nil
+	}
+	parsed, err := schedule.Parse(spec)
+	if err != nil {
+		return spec, nil, errors.New("Invalid 'build'/'schedule' value: " + err.Error())
+	}
+	return spec, parsed, nil
 }
 
-// RunBuild runs the build
-func (v VMImage) RunBuild(skipBuild bool) error {
+// rollbackBuild removes the partial results of a stopped build,
+// keeping the Packer log for troubleshooting
+func (v VMImage) rollbackBuild() {
+	listing, err := ioutil.ReadDir(v.GetWorkDirPath())
+	if err != nil {
+		return
+	}
+	for _, item := range listing {
+		if item.Name() != "packer.log" {
+			os.RemoveAll(v.GetWorkDirPath() + "/" + item.Name())
+		}
+	}
+}
+
+// RunBuild runs the build. If the context is cancelled or the build
+// timeout is reached, the build is stopped and its results removed.
+func (v VMImage) RunBuild(ctx context.Context, skipBuild bool) error {
+	timeout, terr := v.GetBuildTimeout()
+	if terr != nil {
+		return terr
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	err := v.runBuild(ctx, skipBuild)
+	if err != nil && ctx.Err() != nil {
+		v.logger().Println("Build stopped, rolling back...")
+		v.rollbackBuild()
+		if ctx.Err() == context.DeadlineExceeded {
+			return errors.New("Build of '" + v.ImageName + "' timed out")
+		}
+		return ctx.Err()
+	}
+	return err
+}
+
+func (v VMImage) runBuild(ctx context.Context, skipBuild bool) error {
+
+	// Secrets are resolved first, so a missing one fails before anything runs
+	variables, verr := v.resolveVariables()
+	if verr != nil {
+		return verr
+	}
 
 	// Generate the Packer config
-	config, cerr := v.generatePackerConfig()
+	template, cerr := v.generatePackerConfig(v.newBuildInfo())
 	if cerr != nil {
 		return cerr
 	}
-	log.Println("Packer config generated...")
-
-	configFile := v.GetWorkDirPath() + "/builtpacker.json"
-	ioutil.WriteFile(configFile, []byte(config), 0755)
+	format := v.GetTemplateFormat()
+	config, cerr := template.Render(format)
+	if cerr != nil {
+		return cerr
+	}
+	v.logger().Println("Packer config generated...")
 
-	imagefilePath, ok := v.Config.Source["imagefile"]
-	if !ok {
-		return errors.New("Required key source'/'imagefile' not found")
+	cerr = v.saveBuildCredentials(variables.artifactPassword)
+	if cerr != nil {
+		return cerr
 	}
 
-	builderString, ok := v.Config.Source["hypervisor"]
-	if !ok {
-		return errors.New("Required key 'source'/'hypervisor' not found")
+	configFile := v.GetWorkDirPath() + "/" + packer.FileName("builtpacker", format)
+	werr := ioutil.WriteFile(configFile, []byte(config), 0644)
+	if werr != nil {
+		return errors.New("Could not write Packer template " + configFile + ": " + werr.Error())
 	}
 
-	var copyerr error
+	if v.Config.Source.ImageFile == "" {
+		return errors.New("Required key 'source'/'imagefile' not found")
+	}
 
-	// Copy in the current image file into the work directory as our working copy
-	if builderString == "vbox" {
-		copyerr = helpers.CopyFile(v.ImageRootDir+"/"+imagefilePath, v.GetWorkDirPath()+"/original.ova")
-	} else {
-		return errors.New(builderString + " not currently supported")
+	builder, berr := v.getBuilder()
+	if berr != nil {
+		return berr
 	}
 
+	// Copy in the current image file into the work directory as our working copy
+	copyerr := builder.StageSource(v)
 	if copyerr != nil {
 		return copyerr
 	}
 
 	// Check if we want to skip the build, usually for testing
 	if !skipBuild {
-		log.Println("Starting Packer build...")
+		v.logger().Println("Starting Packer build...")
 		// Run the build
-		cwd, _ := os.Getwd()
+		packerLog, ferr := os.OpenFile(v.GetWorkDirPath()+"/packer.log", os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if ferr != nil {
+			return ferr
+		}
+		defer packerLog.Close()
+
+		runner := packer.Runner{
+			PackerPath: settings.Get().PackerPath,
+			Logger:     v.logger(),
+			LogWriter:  packerLog,
+			OnEvent:    v.EventHandler,
+			TotalSteps: template.ProvisionerSteps(),
+			Env:        variables.env(),
+			Secrets:    variables.secrets,
+		}
+		if settings.Get().TempDir != "" {
+			runner.Env = append(runner.Env, "TMPDIR="+settings.Get().TempDir)
+		}
+
+		// HCL templates declare their plugins, which need to be installed first
+		if format == packer.FormatHCL {
+			ierr := runner.Init(ctx, configFile)
+			if ierr != nil {
+				return ierr
+			}
+		}
 
-		cmd := exec.Command(cwd+"/packer", "build", configFile)
-		output, err := cmd.Output()
-		ferr := ioutil.WriteFile(v.GetWorkDirPath()+"/packer.log", output, 0644)
+		err := runner.Build(ctx, configFile)
 		if err != nil {
 			return err
 		}
-		if ferr != nil {
-			return ferr
-		}
-		fmt.Printf("%s", output)
 
 	} else {
-		log.Println("!!! - Faking Packer build...")
+		v.logger().Println("!!! - Faking Packer build...")
 		// Manually create and fill the output directory
 		os.Mkdir(v.GetWorkDirPath()+"/packer-out", 0777)
-		if builderString == "vbox" {
-			originalPath := v.GetWorkDirPath() + "/original.ova"
-			fakingPath := v.GetWorkDirPath() + "/packer-out/" + v.ImageName + "-vmifactory.ova"
-			copyerr = helpers.CopyFile(originalPath, fakingPath)
+		copyerr = helpers.CopyFile(builder.StagedPath(v), builder.ArtifactPath(v))
+		if copyerr != nil {
+			return copyerr
 		}
+		v.artifactIsSource = true
 	}
 
 	// Convert the outputs
-	if builderString == "vbox" {
-		log.Println("Started VBox conversions...")
-
-		ovaPath := v.GetWorkDirPath() + "/packer-out/" + v.ImageName + "-vmifactory.ova"
-		ovaDisks, copyErr := converters.VBoxExtractDisks(v.GetWorkDirPath(), ovaPath)
-
-		if copyErr != nil {
-			return copyErr
-		}
-
-		// Do conversion for KVM
-		kvmName, ok := v.Config.Out["kvm"]
-		if ok && kvmName != "" {
-			log.Println("Doing KVM conversion...")
-			cerr := converters.VBoxToKVM(ovaDisks, v.GetWorkDirPath()+"/"+kvmName)
-			if cerr != nil {
-				return cerr
-			}
-		}
-
-		log.Println("VBox conversions completed...")
-
-		// Remove our work files
-		converters.VBoxCleanup(v.GetWorkDirPath())
-
-		log.Println("VBox cleanup completed...")
-
+	cerr = builder.Convert(ctx, v)
+	if cerr != nil {
+		return cerr
 	}
-	log.Println("Conversions completed...")
+	v.logger().Println("Conversions completed...")
 
-	realName, ok := v.Config.Source["imagefile"]
-	if !ok {
-		return errors.New("Required key 'source'.'imagefile' not found")
-	}
+	realName := v.Config.Source.ImageFile
 
 	// Move the new version back to the original name for post-processing
-	if builderString == "vbox" {
-		// Remove our working copy
-		os.Remove(v.GetWorkDirPath() + "/original.ova")
-		// Move the new Packer copy out
-		copyerr = os.Rename(v.GetWorkDirPath()+"/packer-out/"+v.ImageName+"-vmifactory.ova", v.GetWorkDirPath()+"/"+realName)
-	} else {
-		return errors.New(builderString + " not currently supported")
+	copyerr = builder.Finalize(v, realName)
+	if copyerr != nil {
+		return copyerr
 	}
-	log.Println("Renamed original file...")
+	v.logger().Println("Renamed original file...")
 
-	// Move all the runonce to the used directory
-	runOnceScripts, err := ioutil.ReadDir(v.GetRunOncePath())
-	hadRunOnce := false
-	if err != nil {
-		return errors.New("Could not list the runOnce directory for the image")
+	// Past this point the build is kept, so the runonce scripts are used
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
 
-	for i := 0; i < len(runOnceScripts); i++ {
-		if !(runOnceScripts[i].IsDir()) {
-			oldPath := v.GetRunOncePath() + "/" + runOnceScripts[i].Name()
-			dt := time.Now()
-			newPath := v.GetRunOncePath() + "/used/" + dt.Format("2006-01-02-15.04.05") + runOnceScripts[i].Name()
-			os.Rename(oldPath, newPath)
-			hadRunOnce = true
-		}
+	// Move all the runonce scripts and playbooks to their used directories
+	hadRunOnce, merr := moveRunOnce(v.GetRunOncePath(), nil)
+	if merr != nil {
+		return errors.New("Could not move the runonce scripts of the image: " + merr.Error())
 	}
-
 	if hadRunOnce {
-		log.Println("Moved runonce scripts...")
+		v.logger().Println("Moved runonce scripts...")
 	}
 
-	return nil
-}
-
-// CommitBuild updates the image files and metadata
-func (v VMImage) CommitBuild() error {
-	v.EnableCommitFlag()
-	// Ensure our source image file is the same as its out file name
-	imagefileName, ok := v.Config.Source["imagefile"]
-	if !ok {
-		return errors.New("Required key 'source'.'imagefile' not found")
+	hadRunOnce, merr = moveRunOnce(v.GetAnsibleRunOncePath(), isPlaybook)
+	if merr != nil && !os.IsNotExist(merr) {
+		return errors.New("Could not move the Ansible runonce playbooks of the image: " + merr.Error())
 	}
-	sourceType, ok := v.Config.Source["hypervisor"]
-	if !ok {
-		return errors.New("Required key 'source'.'hypervisor' not found")
-	}
-	v.Config.Out[sourceType] = imagefileName
-
-	log.Println("Updating metadata and moving files...")
-
-	for hypervisor, outFileName := range v.Config.Out {
-		if outFileName != "" {
-
-			oldImagefilePath := v.ImageRootDir + "/Old-" + outFileName
-			currentImagefilePath := v.ImageRootDir + "/" + outFileName
-			newImagefilePath := v.GetWorkDirPath() + "/" + outFileName
-
-			currentHash, ok := v.Config.Metadata[hypervisor+"_current_hash"]
-			if ok {
-				v.Config.Metadata[hypervisor+"_last_hash"] = currentHash
-			} else {
-				v.Config.Metadata[hypervisor+"_last_hash"] = ""
-			}
-			currentBuildDate, ok := v.Config.Metadata[hypervisor+"_current_date"]
-			if ok {
-				v.Config.Metadata[hypervisor+"_last_date"] = currentBuildDate
-			} else {
-				v.Config.Metadata[hypervisor+"_last_date"] = ""
-			}
-			fileHash, err := helpers.GetFileSHA256(newImagefilePath)
-			if err != nil {
-				return err
-			}
-			v.Config.Metadata[hypervisor+"_current_hash"] = fileHash
-			dt := time.Now()
-			//
-			v.Config.Metadata[hypervisor+"_current_date"] = dt.Format("2006-01-02 15:04:05")
-
-			// Remove the old image if it exists
-			_, err = os.Stat(oldImagefilePath)
-			if err == nil {
-				os.Remove(oldImagefilePath)
-			}
-
-			// Move the existing to the Old- name
-			_, err = os.Stat(currentImagefilePath)
-			if err == nil {
-				os.Rename(currentImagefilePath, oldImagefilePath)
-			}
-
-			// Move the new to the current path
-			os.Rename(newImagefilePath, currentImagefilePath)
-
-		}
-		v.saveJSON()
-		v.DisableCommitFlag()
+	if hadRunOnce {
+		v.logger().Println("Moved runonce playbooks...")
 	}
 
 	return nil
@@ -0,0 +1,61 @@
+package imagemanage
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestVagrantGuestPassword(t *testing.T) {
+	tests := []struct {
+		password string
+		rotate   bool
+		want     string
+	}{
+		{"vagrant", false, "vagrant"},
+		{"vagrant", true, ""},
+		{"env:VM_PASSWORD", false, ""},
+		{"file:password.txt", false, ""},
+		{"keystore:vm", false, ""},
+	}
+	for _, test := range tests {
+		config := &BuilderConfig{}
+		config.Login = LoginConfig{Username: "vagrant", Password: test.password, Rotate: test.rotate}
+		v := VMImage{ImageName: "test", Config: config}
+		got := v.vagrantGuest()
+		if got.Password != test.want || got.Username != "vagrant" {
+			t.Errorf("vagrantGuest() with password %q, rotate %v = %+v, want password %q", test.password, test.rotate, got, test.want)
+		}
+	}
+}
+
+func TestMoveRunOnce(t *testing.T) {
+	dirPath := t.TempDir()
+	werr := ioutil.WriteFile(dirPath+"/once.sh", []byte("echo once"), 0644)
+	if werr != nil {
+		t.Fatal(werr)
+	}
+	// The used directory cannot be made over a file
+	werr = ioutil.WriteFile(dirPath+"/used", []byte{}, 0644)
+	if werr != nil {
+		t.Fatal(werr)
+	}
+	moved, merr := moveRunOnce(dirPath, nil)
+	if merr == nil || moved {
+		t.Errorf("moveRunOnce() = %v, %v, want an error", moved, merr)
+	}
+
+	os.Remove(dirPath + "/used")
+	moved, merr = moveRunOnce(dirPath, nil)
+	if merr != nil || !moved {
+		t.Fatalf("moveRunOnce() = %v, %v, want true", moved, merr)
+	}
+	listing, _ := ioutil.ReadDir(dirPath + "/used")
+	if len(listing) != 1 || !strings.HasSuffix(listing[0].Name(), "once.sh") {
+		t.Errorf("used directory has %v, want the script", listing)
+	}
+	if _, serr := os.Stat(dirPath + "/once.sh"); serr == nil {
+		t.Error("moved script is still in the runonce directory")
+	}
+}
@@ -0,0 +1,139 @@
+package imagemanage
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bocajspear1/vmifactory/internal/helpers"
+	"github.com/bocajspear1/vmifactory/internal/packer"
+)
+
+var imageNameRegex = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// ValidImageName checks if the name is lowercase and '-' separated
+func ValidImageName(imageName string) bool {
+	return imageNameRegex.MatchString(imageName)
+}
+
+// SafeImageDirName checks if the name can only refer to a directory
+// inside the image directory. Unlike ValidImageName it accepts the names
+// of images made before the naming rule.
+func SafeImageDirName(imageName string) bool {
+	return imageName != "" && imageName != "." && imageName != ".." && !strings.ContainsAny(imageName, "/\\")
+}
+
+// starterConfig is a new image config with comments explaining each section
+type starterConfig struct {
+	Comments map[string]string `json:"_comments"`
+	BuilderConfig
+}
+
+var starterComments = map[string]string{
+	"name":        "Display name of the image on the download page",
+	"description": "Description of the image on the download page",
+	"guest_os":    "linux or windows, Windows guests are logged in to with WinRM and run .ps1, .bat and .cmd scripts",
+	"login":       "Credentials Packer logs in with, also shown on the download page. Passwords may be secret references: env:NAME, file:/path or keystore:NAME. Set rotate to true for a new random password every build",
+	"ssh":         "Optional SSH settings: private_key_file, agent, port, timeout, bastion_* and authorized_keys, public keys added to every build",
+	"windows":     "Optional Windows guest settings: sysprep, shutdown_timeout and winrm_port, winrm_use_ssl, winrm_insecure, winrm_timeout",
+	"ansible":     "Settings for ansible/playbook.yml and the playbooks in ansible/runonce: mode is remote or local, roles_paths are shared role directories, extra_arguments are for ansible-playbook",
+	"files":       "The files directory is copied into the guest before the scripts run, files/etc/motd goes to /etc/motd. rules set the owner and mode of paths like {\"path\": \"/etc/app.conf\", \"owner\": \"app:app\", \"mode\": \"0600\"}",
+	"vmware":      "disk_format is the VMDK subformat of the vmware output, monolithicSparse (the default) or streamOptimized",
+	"source":      "hypervisor is the builder used (vbox or kvm), imagefile is the file in this directory builds start from",
+	"out":         "File names of the outputs for each hypervisor, leave a name empty to skip that output. vagrant_virtualbox and vagrant_libvirt are Vagrant .box files",
+	"build":       "template_format is hcl or json, build_timeout is like '6h', schedule is a cron expression, '@daily' or '@every 24h'",
+	"env":         "Environment variables for the run and runonce scripts, which also get VMIF_IMAGE_NAME, VMIF_BUILD_ID, VMIF_BUILD_DATE and VMIF_PREVIOUS_HASH",
+	"metadata":    "Free-form information about the image, build hashes and dates are kept in <image-name>.state.json",
+}
+
+// InitImage creates the directory tree and a starter config for a new
+// image. If importPath is set, that file is copied in as the source image.
+func InitImage(path string, imageName string, hypervisor string, importPath string) (*VMImage, error) {
+	if !ValidImageName(imageName) {
+		return nil, errors.New("Image name '" + imageName + "' must be lowercase and '-' separated")
+	}
+	builder, berr := GetBuilder(hypervisor)
+	if berr != nil {
+		return nil, berr
+	}
+
+	v := new(VMImage)
+	v.ImageName = imageName
+	v.ImageRootDir = path + "/" + imageName
+
+	_, serr := os.Stat(v.ImageRootDir)
+	if serr == nil {
+		return nil, errors.New("Image directory '" + v.ImageRootDir + "' already exists")
+	}
+
+	for _, dirPath := range []string{v.ImageRootDir, v.GetRunPath(), v.GetRunOncePath(), v.GetRunOncePath() + "/used"} {
+		merr := os.MkdirAll(dirPath, 0777)
+		if merr != nil {
+			return nil, merr
+		}
+	}
+
+	imagefileName := imageName + builder.SourceExtension()
+	state := newImageState()
+	if importPath != "" {
+		imagefileName = filepath.Base(importPath)
+		copyerr := helpers.CopyFile(importPath, v.ImageRootDir+"/"+imagefileName)
+		if copyerr != nil {
+			return nil, copyerr
+		}
+		fileHash, herr := helpers.GetFileSHA256(v.ImageRootDir + "/" + imagefileName)
+		if herr != nil {
+			return nil, herr
+		}
+		state.Outputs[hypervisor] = OutputState{
+			CurrentHash: fileHash,
+			CurrentDate: time.Now().Format("2006-01-02 15:04:05"),
+		}
+	}
+
+	var out OutputConfig
+	out.Set(hypervisor, imagefileName)
+	if hypervisor == "vbox" {
+		out.KVM = imageName + ".tar.gz"
+	}
+
+	config := starterConfig{
+		Comments: starterComments,
+		BuilderConfig: BuilderConfig{
+			SchemaVersion: CurrentSchemaVersion,
+			Name:          imageName,
+			GuestOS:       GuestLinux,
+			Source: SourceConfig{
+				Hypervisor: hypervisor,
+				ImageFile:  imagefileName,
+			},
+			Out: out,
+			Build: BuildConfig{
+				TemplateFormat: packer.FormatHCL,
+			},
+			Env:      make(map[string]string),
+			Metadata: make(map[string]string),
+		},
+	}
+
+	configData, merr := json.MarshalIndent(config, "", "    ")
+	if merr != nil {
+		return nil, merr
+	}
+	werr := ioutil.WriteFile(v.GetConfigPath(), configData, 0644)
+	if werr != nil {
+		return nil, werr
+	}
+	v.State = state
+	serr = v.saveState()
+	if serr != nil {
+		return nil, serr
+	}
+
+	return NewVMImage(path, imageName)
+}
@@ -0,0 +1,180 @@
+package imagemanage
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/bocajspear1/vmifactory/internal/helpers"
+)
+
+// LockInfo records who holds an image's build lock
+type LockInfo struct {
+	PID     int    `json:"pid"`
+	Host    string `json:"host"`
+	Started string `json:"started"`
+}
+
+// BuildLock is a held build lock for an image
+type BuildLock struct {
+	file *os.File
+}
+
+// lockWait is how long Lock retries a lock that is taken, in case it is
+// only checked by IsLocked
+var lockWait = 2 * time.Second
+
+// GetLockPath returns the path to the build lock file. It is kept out of
+// the work directory, which is removed when a build starts.
+func (v VMImage) GetLockPath() string {
+	return v.ImageRootDir + "/build.lock"
+}
+
+// ReadLock returns the owner of the image's build lock
+func (v VMImage) ReadLock() (*LockInfo, error) {
+	data, ferr := ioutil.ReadFile(v.GetLockPath())
+	if ferr != nil {
+		return nil, ferr
+	}
+	var info LockInfo
+	jerr := json.Unmarshal(data, &info)
+	if jerr != nil {
+		return nil, jerr
+	}
+	return &info, nil
+}
+
+// openLock opens the lock file and takes its lock. The file is never
+// removed while locked, unless forced, so a lock taken on a file that
+// has since been replaced is given up and taken again on the new one.
+func (v VMImage) openLock() (*os.File, error) {
+	for {
+		lockFile, oerr := os.OpenFile(v.GetLockPath(), os.O_CREATE|os.O_RDWR, 0644)
+		if oerr != nil {
+			return nil, oerr
+		}
+		lerr := helpers.LockFile(lockFile, false)
+		if lerr != nil {
+			lockFile.Close()
+			return nil, lerr
+		}
+		fileInfo, ferr := lockFile.Stat()
+		pathInfo, perr := os.Stat(v.GetLockPath())
+		if ferr == nil && perr == nil && os.SameFile(fileInfo, pathInfo) {
+			return lockFile, nil
+		}
+		lockFile.Close()
+		if ferr != nil {
+			return nil, ferr
+		}
+	}
+}
+
+// Lock takes the build lock of the image, so only one build of it runs
+// at a time. The lock is held on the open lock file, so it is released
+// when the build's process dies.
+func (v VMImage) Lock() (*BuildLock, error) {
+	hostname, _ := os.Hostname()
+	info := LockInfo{
+		PID:     os.Getpid(),
+		Host:    hostname,
+		Started: time.Now().Format("2006-01-02 15:04:05"),
+	}
+	data, merr := json.Marshal(info)
+	if merr != nil {
+		return nil, merr
+	}
+
+	deadline := time.Now().Add(lockWait)
+	for {
+		lockFile, lerr := v.openLock()
+		if lerr == nil {
+			// A process that died holding the lock leaves its owner behind
+			previous, _ := ioutil.ReadAll(lockFile)
+			var owner LockInfo
+			if json.Unmarshal(previous, &owner) == nil {
+				v.logger().Println("Taking over build lock of '" + v.ImageName + "' left by PID " + strconv.Itoa(owner.PID) + " on " + owner.Host + "...")
+			}
+			werr := writeLockInfo(lockFile, data)
+			if werr != nil {
+				lockFile.Close()
+				return nil, werr
+			}
+			return &BuildLock{file: lockFile}, nil
+		}
+		if lerr != helpers.ErrFileLocked {
+			return nil, lerr
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	owner, rerr := v.ReadLock()
+	if rerr != nil {
+		return nil, errors.New("Image '" + v.ImageName + "' is locked by another build")
+	}
+	return nil, errors.New("Image '" + v.ImageName + "' is being built by PID " + strconv.Itoa(owner.PID) +
+		" on " + owner.Host + " since " + owner.Started)
+}
+
+// writeLockInfo replaces the owner recorded in the lock file
+func writeLockInfo(lockFile *os.File, data []byte) error {
+	terr := lockFile.Truncate(0)
+	if terr != nil {
+		return terr
+	}
+	_, werr := lockFile.WriteAt(data, 0)
+	if werr != nil {
+		return werr
+	}
+	return lockFile.Sync()
+}
+
+// Unlock releases the build lock. The lock file is kept, removing it
+// would let a build lock a file that is about to disappear.
+func (l *BuildLock) Unlock() error {
+	terr := l.file.Truncate(0)
+	uerr := helpers.UnlockFile(l.file)
+	cerr := l.file.Close()
+	if terr != nil {
+		return terr
+	}
+	if uerr != nil {
+		return uerr
+	}
+	return cerr
+}
+
+// IsLocked checks if the image is locked by a running build
+func (v VMImage) IsLocked() bool {
+	lockFile, oerr := os.Open(v.GetLockPath())
+	if oerr != nil {
+		return !os.IsNotExist(oerr)
+	}
+	defer lockFile.Close()
+	lerr := helpers.LockFile(lockFile, true)
+	if lerr != nil {
+		return true
+	}
+	helpers.UnlockFile(lockFile)
+	return false
+}
+
+// ForceUnlock removes the build lock file, for a build that hangs while
+// holding it. A build still holding it keeps running.
+func (v VMImage) ForceUnlock() error {
+	owner, err := v.ReadLock()
+	if err == nil {
+		v.logger().Println("Forcing unlock of '" + v.ImageName + "', held by PID " + strconv.Itoa(owner.PID) + " on " + owner.Host + " since " + owner.Started)
+	}
+	rerr := os.Remove(v.GetLockPath())
+	if rerr != nil && !os.IsNotExist(rerr) {
+		return rerr
+	}
+	return nil
+}
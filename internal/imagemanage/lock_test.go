@@ -0,0 +1,86 @@
+package imagemanage
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	lockWait = 200 * time.Millisecond
+	v := VMImage{ImageName: "test", ImageRootDir: t.TempDir(), Logger: log.New(ioutil.Discard, "", 0)}
+	if v.IsLocked() {
+		t.Error("IsLocked() = true before locking")
+	}
+
+	lock, lerr := v.Lock()
+	if lerr != nil {
+		t.Fatalf("Lock() error = %v", lerr)
+	}
+	if !v.IsLocked() {
+		t.Error("IsLocked() = false while locked")
+	}
+	// Locks are held on the open file, so a second one conflicts even in
+	// the same process
+	_, lerr = v.Lock()
+	if lerr == nil || !strings.Contains(lerr.Error(), "PID "+strconv.Itoa(os.Getpid())) {
+		t.Errorf("second Lock() error = %v, want it to name the owner", lerr)
+	}
+
+	uerr := lock.Unlock()
+	if uerr != nil {
+		t.Fatalf("Unlock() error = %v", uerr)
+	}
+	if v.IsLocked() {
+		t.Error("IsLocked() = true after unlocking")
+	}
+	lock, lerr = v.Lock()
+	if lerr != nil {
+		t.Fatalf("Lock() after Unlock() error = %v", lerr)
+	}
+	lock.Unlock()
+}
+
+func TestLockLeftByDeadProcess(t *testing.T) {
+	v := VMImage{ImageName: "test", ImageRootDir: t.TempDir(), Logger: log.New(ioutil.Discard, "", 0)}
+	// The file of a build that died is left, but not its lock
+	werr := ioutil.WriteFile(v.GetLockPath(), []byte(`{"pid": 999999, "host": "elsewhere", "started": "2026-01-01 00:00:00"}`), 0644)
+	if werr != nil {
+		t.Fatal(werr)
+	}
+	if v.IsLocked() {
+		t.Error("IsLocked() = true for a lock file nobody holds")
+	}
+	lock, lerr := v.Lock()
+	if lerr != nil {
+		t.Fatalf("Lock() error = %v", lerr)
+	}
+	defer lock.Unlock()
+	owner, rerr := v.ReadLock()
+	if rerr != nil || owner.Host == "elsewhere" {
+		t.Errorf("ReadLock() = %+v, %v, want this process", owner, rerr)
+	}
+}
+
+func TestForceUnlock(t *testing.T) {
+	lockWait = 200 * time.Millisecond
+	v := VMImage{ImageName: "test", ImageRootDir: t.TempDir(), Logger: log.New(ioutil.Discard, "", 0)}
+	hung, lerr := v.Lock()
+	if lerr != nil {
+		t.Fatalf("Lock() error = %v", lerr)
+	}
+	defer hung.Unlock()
+	ferr := v.ForceUnlock()
+	if ferr != nil {
+		t.Fatalf("ForceUnlock() error = %v", ferr)
+	}
+	lock, lerr := v.Lock()
+	if lerr != nil {
+		t.Fatalf("Lock() after ForceUnlock() error = %v", lerr)
+	}
+	lock.Unlock()
+}
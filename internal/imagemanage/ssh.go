@@ -0,0 +1,103 @@
+package imagemanage
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/bocajspear1/vmifactory/internal/packer"
+)
+
+// authorizedKeyTypes are the key type prefixes accepted in authorized_keys
+var authorizedKeyTypes = []string{"ssh-", "ecdsa-", "sk-"}
+
+// validAuthorizedKey checks that a line looks like an OpenSSH public key
+func validAuthorizedKey(line string) bool {
+	if strings.ContainsAny(line, "\n\r") {
+		return false
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return false
+	}
+	for _, prefix := range authorizedKeyTypes {
+		if strings.HasPrefix(fields[0], prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// imageFilePath returns the absolute path of a file the config refers to,
+// relative paths being relative to the image directory
+func (v VMImage) imageFilePath(path string) string {
+	if !filepath.IsAbs(path) {
+		path = v.ImageRootDir + "/" + path
+	}
+	absPath, _ := filepath.Abs(path)
+	return absPath
+}
+
+// addSSHSettings adds the SSH communicator settings to the source
+func (v VMImage) addSSHSettings(settings packer.Settings) {
+	ssh := v.Config.SSH
+	if ssh.PrivateKeyFile != "" {
+		settings["ssh_private_key_file"] = v.imageFilePath(ssh.PrivateKeyFile)
+	}
+	if ssh.Agent {
+		settings["ssh_agent_auth"] = true
+	}
+	if ssh.Port != 0 {
+		settings["ssh_port"] = ssh.Port
+	}
+	if ssh.Timeout != "" {
+		settings["ssh_timeout"] = ssh.Timeout
+	}
+	if ssh.HandshakeAttempts != 0 {
+		settings["ssh_handshake_attempts"] = ssh.HandshakeAttempts
+	}
+	if ssh.BastionHost != "" {
+		settings["ssh_bastion_host"] = ssh.BastionHost
+		if ssh.BastionPort != 0 {
+			settings["ssh_bastion_port"] = ssh.BastionPort
+		}
+		if ssh.BastionUsername != "" {
+			settings["ssh_bastion_username"] = ssh.BastionUsername
+		}
+		if ssh.BastionPrivateKeyFile != "" {
+			settings["ssh_bastion_private_key_file"] = v.imageFilePath(ssh.BastionPrivateKeyFile)
+		}
+		if ssh.BastionAgent {
+			settings["ssh_bastion_agent_auth"] = true
+		}
+	}
+}
+
+// authorizedKeysProvisioner returns the provisioner that adds the
+// authorized keys of the config for the login user, skipping keys
+// that are already there
+func (v VMImage) authorizedKeysProvisioner() packer.Provisioner {
+	user := shellQuote(v.Config.Login.Username)
+	commands := []string{
+		"home=$(getent passwd " + user + " | cut -d: -f6)",
+		"group=$(id -gn " + user + ")",
+		`mkdir -p "$home/.ssh"`,
+		`touch "$home/.ssh/authorized_keys"`,
+	}
+	for _, key := range v.Config.SSH.AuthorizedKeys {
+		quotedKey := shellQuote(strings.TrimSpace(key))
+		commands = append(commands, `grep -qxF `+quotedKey+` "$home/.ssh/authorized_keys" || printf '%s\n' `+quotedKey+` >> "$home/.ssh/authorized_keys"`)
+	}
+	commands = append(commands,
+		`chown `+user+`:"$group" "$home/.ssh" "$home/.ssh/authorized_keys"`,
+		`chmod 700 "$home/.ssh"`,
+		`chmod 600 "$home/.ssh/authorized_keys"`,
+	)
+
+	return packer.Provisioner{
+		Type: "shell",
+		Settings: packer.Settings{
+			"inline":          commands,
+			"execute_command": sudoCommand("env {{ .Vars }} {{ .Path }}"),
+		},
+	}
+}
@@ -0,0 +1,336 @@
+package imagemanage
+
+import (
+	_ "embed"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bocajspear1/vmifactory/internal/converters"
+	"github.com/bocajspear1/vmifactory/internal/jsonschema"
+	"github.com/bocajspear1/vmifactory/internal/packer"
+	"github.com/bocajspear1/vmifactory/internal/secrets"
+	"github.com/bocajspear1/vmifactory/internal/settings"
+)
+
+//go:embed image-config.schema.json
+var imageConfigSchema []byte
+
+// ConfigSchema returns the JSON Schema for image config files
+func ConfigSchema() []byte {
+	return imageConfigSchema
+}
+
+// ValidationError is a problem with an image's config or files
+type ValidationError struct {
+	File    string
+	Key     string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	if e.Key == "" {
+		return e.File + ": " + e.Message
+	}
+	return e.File + ": " + e.Key + ": " + e.Message
+}
+
+// legalFileName checks that a config file name stays in the image directory
+func legalFileName(name string) bool {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+		return false
+	}
+	for _, c := range name {
+		if c < ' ' {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateImage loads and checks an image, reporting a config that cannot
+// be parsed as a problem instead of failing
+func ValidateImage(path string, imageName string) []ValidationError {
+	image, ierr := NewVMImage(path, imageName)
+	if ierr != nil {
+		return []ValidationError{{File: path + "/" + imageName + "/" + imageName + ".json", Message: ierr.Error()}}
+	}
+	return image.Validate()
+}
+
+// Validate checks the image config against the schema, then checks
+// the values and the files they refer to. Every problem found is returned.
+func (v VMImage) Validate() []ValidationError {
+	configPath := v.GetConfigPath()
+	problems := make([]ValidationError, 0)
+	add := func(key string, message string) {
+		problems = append(problems, ValidationError{File: configPath, Key: key, Message: message})
+	}
+
+	// Only new images have to follow the naming rule, existing ones keep building
+	if !ValidImageName(v.ImageName) {
+		v.logger().Println("Warning: image directory name '" + v.ImageName + "' is not lowercase and '-' separated, as new images must be")
+	}
+
+	// The schema is for the current version, older configs are migrated first
+	if v.NeedsMigration() {
+		add("schema_version", "config is schema version "+strconv.Itoa(v.loadedVersion)+", run 'vmif-run migrate "+v.ImageName+"' to upgrade it to version "+strconv.Itoa(CurrentSchemaVersion))
+		return problems
+	}
+
+	configData, ferr := ioutil.ReadFile(configPath)
+	if ferr != nil {
+		add("", "could not read config: "+ferr.Error())
+		return problems
+	}
+	schemaProblems, serr := jsonschema.Validate(imageConfigSchema, configData)
+	if serr != nil {
+		add("", "could not check config: "+serr.Error())
+	}
+	for _, problem := range schemaProblems {
+		add(problem.Path, problem.Message)
+	}
+
+	// Keys the schema already found problems with are not checked again
+	schemaKeys := make(map[string]bool)
+	for _, problem := range schemaProblems {
+		schemaKeys[problem.Path] = true
+	}
+	add = func(key string, message string) {
+		if key == "" || !schemaKeys[key] {
+			problems = append(problems, ValidationError{File: configPath, Key: key, Message: message})
+		}
+	}
+
+	// Source image
+	sourceType := v.Config.Source.Hypervisor
+	builder, berr := GetBuilder(sourceType)
+	if berr != nil {
+		add("source.hypervisor", "unknown hypervisor '"+sourceType+"', must be one of: "+strings.Join(GetBuilderNames(), ", "))
+	}
+	imagefileName := v.Config.Source.ImageFile
+	if !legalFileName(imagefileName) {
+		add("source.imagefile", "'"+imagefileName+"' is not a file name in the image directory")
+	} else if _, err := os.Stat(v.ImageRootDir + "/" + imagefileName); err != nil {
+		add("source.imagefile", "source image '"+imagefileName+"' does not exist")
+	}
+
+	// Outputs
+	seen := make(map[string]string)
+	for _, hypervisor := range OutputNames {
+		outFileName := v.Config.Out.Get(hypervisor)
+		if outFileName == "" {
+			continue
+		}
+		key := "out." + hypervisor
+		if !legalFileName(outFileName) {
+			add(key, "'"+outFileName+"' is not a file name in the image directory")
+		} else if strings.HasPrefix(outFileName, "Old-") {
+			add(key, "'"+outFileName+"' must not start with 'Old-', that is used for previous builds")
+		}
+		if hypervisor == "hyperv" && !strings.HasSuffix(outFileName, ".zip") {
+			add(key, "'"+outFileName+"' must end with '.zip', the VHDX disks are zipped")
+		}
+		if hypervisor == "vmware" && !strings.HasSuffix(outFileName, ".zip") && !strings.HasSuffix(outFileName, ".tar.gz") {
+			add(key, "'"+outFileName+"' must end with '.zip' or '.tar.gz', the VMDK disks and VMX are packed together")
+		}
+		if strings.HasPrefix(hypervisor, "vagrant_") && !strings.HasSuffix(outFileName, ".box") {
+			add(key, "'"+outFileName+"' must end with '.box'")
+		}
+		if other, ok := seen[outFileName]; ok {
+			add(key, "'"+outFileName+"' is also the output of out."+other)
+		}
+		seen[outFileName] = hypervisor
+		if builder != nil && !stringInList(hypervisor, builder.Outputs()) {
+			add(key, "'"+sourceType+"' sources cannot produce '"+hypervisor+"' outputs")
+		}
+	}
+	if builder != nil {
+		sourceOut := v.Config.Out.Get(sourceType)
+		if sourceOut != "" && sourceOut != imagefileName {
+			add("out."+sourceType, "must be empty or the same as source.imagefile, the source image is rebuilt in place")
+		}
+	}
+
+	// Credentials
+	if rerr := secrets.CheckReference(v.Config.Login.Password); rerr != nil {
+		add("login.password", rerr.Error())
+	}
+	if rerr := secrets.CheckReference(v.Config.Login.SudoPassword); rerr != nil {
+		add("login.sudo_password", rerr.Error())
+	}
+
+	if v.Config.Login.Rotate && v.Config.Login.SudoPassword != v.Config.Login.Password {
+		add("login.sudo_password", "must be the same as login.password with login.rotate, sudo asks for the user's own password")
+	}
+
+	// SSH
+	ssh := v.Config.SSH
+	keyFiles := [][2]string{{"ssh.private_key_file", ssh.PrivateKeyFile}, {"ssh.bastion_private_key_file", ssh.BastionPrivateKeyFile}}
+	for _, keyFile := range keyFiles {
+		if keyFile[1] == "" {
+			continue
+		}
+		if _, err := os.Stat(v.imageFilePath(keyFile[1])); err != nil {
+			add(keyFile[0], "key file "+v.imageFilePath(keyFile[1])+" does not exist")
+		}
+	}
+	if ssh.Port < 0 || ssh.Port > 65535 {
+		add("ssh.port", "must be a port number")
+	}
+	if ssh.BastionPort < 0 || ssh.BastionPort > 65535 {
+		add("ssh.bastion_port", "must be a port number")
+	}
+	if _, terr := time.ParseDuration(ssh.Timeout); ssh.Timeout != "" && terr != nil {
+		add("ssh.timeout", "'"+ssh.Timeout+"' is not a duration like '30m'")
+	}
+	if ssh.BastionHost == "" && (ssh.BastionPort != 0 || ssh.BastionUsername != "" || ssh.BastionPrivateKeyFile != "" || ssh.BastionAgent) {
+		add("ssh.bastion_host", "must be set to use the other bastion settings")
+	}
+	for i, key := range ssh.AuthorizedKeys {
+		if !validAuthorizedKey(key) {
+			add("ssh.authorized_keys["+strconv.Itoa(i)+"]", "is not an OpenSSH public key line like 'ssh-ed25519 AAAA... comment'")
+		}
+	}
+
+	// Guest OS
+	guestOS := v.Config.GuestOS
+	if guestOS != "" && guestOS != GuestLinux && guestOS != GuestWindows {
+		add("guest_os", "must be '"+GuestLinux+"' or '"+GuestWindows+"'")
+	}
+	windows := v.Config.Windows
+	if v.IsWindows() {
+		// WinRM logs in again for every command, so the shutdown would fail after a password change
+		if v.Config.Login.Rotate {
+			add("login.rotate", "is not supported for Windows guests")
+		}
+		if len(ssh.AuthorizedKeys) > 0 {
+			add("ssh.authorized_keys", "is not supported for Windows guests")
+		}
+	} else if windows != (WindowsConfig{}) {
+		add("windows", "is only used for Windows guests, set guest_os to '"+GuestWindows+"'")
+	}
+	if windows.WinRMPort < 0 || windows.WinRMPort > 65535 {
+		add("windows.winrm_port", "must be a port number")
+	}
+	if _, terr := time.ParseDuration(windows.ShutdownTimeout); windows.ShutdownTimeout != "" && terr != nil {
+		add("windows.shutdown_timeout", "'"+windows.ShutdownTimeout+"' is not a duration like '1h'")
+	}
+	if _, terr := time.ParseDuration(windows.WinRMTimeout); windows.WinRMTimeout != "" && terr != nil {
+		add("windows.winrm_timeout", "'"+windows.WinRMTimeout+"' is not a duration like '30m'")
+	}
+
+	// Ansible
+	ansible := v.Config.Ansible
+	if ansible.Mode != "" && ansible.Mode != AnsibleRemote && ansible.Mode != AnsibleLocal {
+		add("ansible.mode", "must be '"+AnsibleRemote+"' or '"+AnsibleLocal+"'")
+	}
+	for i, rolesPath := range ansible.RolesPaths {
+		if info, err := os.Stat(v.imageFilePath(rolesPath)); err != nil || !info.IsDir() {
+			add("ansible.roles_paths["+strconv.Itoa(i)+"]", "roles directory "+v.imageFilePath(rolesPath)+" does not exist")
+		}
+	}
+	if v.IsWindows() && v.HasAnsible() {
+		add("", "Ansible playbooks in "+v.GetAnsiblePath()+" are not supported for Windows guests")
+	}
+	if listing, lerr := ioutil.ReadDir(v.GetAnsibleRunOncePath()); lerr == nil {
+		for _, item := range listing {
+			if !item.IsDir() && !isPlaybook(item.Name()) {
+				add("", "Ansible runonce directory "+v.GetAnsibleRunOncePath()+" contains '"+item.Name()+"', only .yml and .yaml playbooks are run")
+			}
+		}
+	}
+
+	// Uploaded files
+	guestFiles, gerr := v.listGuestFiles()
+	if gerr != nil {
+		add("", gerr.Error())
+	}
+	if v.IsWindows() && len(guestFiles) > 0 {
+		add("", "uploaded files in "+v.GetFilesPath()+" are not supported for Windows guests")
+	}
+	for i, rule := range v.Config.Files.Rules {
+		key := "files.rules[" + strconv.Itoa(i) + "]"
+		found := false
+		for _, file := range guestFiles {
+			if file.GuestPath == strings.TrimSuffix(rule.Path, "/") {
+				found = true
+			}
+		}
+		if !found && gerr == nil {
+			add(key+".path", "'"+rule.Path+"' is not in "+v.GetFilesPath())
+		}
+		if rule.Owner != "" && !fileOwnerRegex.MatchString(rule.Owner) {
+			add(key+".owner", "'"+rule.Owner+"' is not an owner like 'user' or 'user:group'")
+		}
+		if rule.Mode != "" && !fileModeRegex.MatchString(rule.Mode) {
+			add(key+".mode", "'"+rule.Mode+"' is not an octal mode like '0644'")
+		}
+	}
+
+	// VMware output
+	vmdkFormat := v.GetVMDKFormat()
+	if vmdkFormat != converters.VMDKMonolithicSparse && vmdkFormat != converters.VMDKStreamOptimized {
+		add("vmware.disk_format", "must be '"+converters.VMDKMonolithicSparse+"' or '"+converters.VMDKStreamOptimized+"'")
+	}
+
+	// Script environment
+	envNames := make([]string, 0, len(v.Config.Env))
+	for name := range v.Config.Env {
+		envNames = append(envNames, name)
+	}
+	sort.Strings(envNames)
+	for _, name := range envNames {
+		if !settings.ValidEnvName(name) {
+			add("env."+name, "is not a valid variable name, use letters, digits and '_', VMIF_ names are reserved")
+		}
+	}
+
+	// Build settings
+	format := v.GetTemplateFormat()
+	if format != packer.FormatHCL && format != packer.FormatJSON {
+		add("build.template_format", "must be '"+packer.FormatHCL+"' or '"+packer.FormatJSON+"'")
+	}
+	if _, terr := v.GetBuildTimeout(); terr != nil {
+		add("build.build_timeout", "'"+v.Config.Build.BuildTimeout+"' is not a duration like '6h' or '90m'")
+	}
+	if _, _, serr := v.GetSchedule(); serr != nil {
+		add("build.schedule", serr.Error())
+	}
+
+	// Script directories
+	for _, dirPath := range []string{v.GetRunPath(), v.GetRunOncePath()} {
+		listing, lerr := ioutil.ReadDir(dirPath)
+		if lerr != nil {
+			add("", "script directory "+dirPath+" is missing")
+			continue
+		}
+		for _, item := range listing {
+			if item.IsDir() {
+				if !(dirPath == v.GetRunOncePath() && item.Name() == "used") {
+					add("", "script directory "+dirPath+" contains the directory '"+item.Name()+"', only scripts are run")
+				}
+			} else if v.scriptProvisionerType(item.Name()) == "" {
+				if v.IsWindows() {
+					add("", "script "+dirPath+"/"+item.Name()+" cannot be run on Windows, use .ps1, .bat or .cmd scripts or an empty .restart file")
+				} else {
+					add("", "script "+dirPath+"/"+item.Name()+" is a Windows restart marker, set guest_os to '"+GuestWindows+"' or remove it")
+				}
+			}
+		}
+	}
+
+	return problems
+}
+
+func stringInList(value string, list []string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
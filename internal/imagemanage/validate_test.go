@@ -0,0 +1,109 @@
+package imagemanage
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/bocajspear1/vmifactory/internal/jsonschema"
+)
+
+func TestStarterConfigMatchesSchema(t *testing.T) {
+	path := t.TempDir()
+	image, ierr := InitImage(path, "starter", "vbox", "")
+	if ierr != nil {
+		t.Fatalf("InitImage() error = %v", ierr)
+	}
+	// The starter has no source image or login yet, which users fill in
+	fillIn := []string{"source.imagefile", "login.username", "login.password"}
+	for _, problem := range image.Validate() {
+		if !stringInList(problem.Key, fillIn) {
+			t.Errorf("starter config problem: %s", problem.Error())
+		}
+	}
+}
+
+func TestConfigSchemaProblems(t *testing.T) {
+	config := `{
+		"schema_version": 2,
+		"name": "test",
+		"guest_os": "plan9",
+		"login": {"username": "u", "password": "p", "sudo_password": "p", "rotat": true},
+		"source": {"hypervisor": "vbox"},
+		"out": {"vbox": 5}
+	}`
+	problems, err := jsonschema.Validate(imageConfigSchema, []byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"guest_os":         "not one of",
+		"login.rotat":      "is not a known key",
+		"source.imagefile": "is required",
+		"out.vbox":         "must be a string, not an integer",
+	}
+	for _, problem := range problems {
+		message, ok := want[problem.Path]
+		if !ok {
+			t.Errorf("unexpected problem %s: %s", problem.Path, problem.Message)
+			continue
+		}
+		if !strings.Contains(problem.Message, message) {
+			t.Errorf("problem %s = %q, want it to contain %q", problem.Path, problem.Message, message)
+		}
+		delete(want, problem.Path)
+	}
+	for path := range want {
+		t.Errorf("no problem reported for %s", path)
+	}
+}
+
+func TestValidateAllowsExistingImageNames(t *testing.T) {
+	path := t.TempDir()
+	_, ierr := InitImage(path, "old-image", "vbox", "")
+	if ierr != nil {
+		t.Fatalf("InitImage() error = %v", ierr)
+	}
+	// Images made before the naming rule have names init refuses
+	rerr := os.Rename(path+"/old-image/old-image.json", path+"/old-image/Old_Image.json")
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	rerr = os.Rename(path+"/old-image", path+"/Old_Image")
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	image, nerr := NewVMImage(path, "Old_Image")
+	if nerr != nil {
+		t.Fatalf("NewVMImage() error = %v", nerr)
+	}
+	image.Logger = log.New(ioutil.Discard, "", 0)
+	for _, problem := range image.Validate() {
+		if problem.Key == "" {
+			t.Errorf("Validate() problem for an existing image name: %s", problem.Error())
+		}
+	}
+}
+
+func TestSafeImageDirName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"my-image", true},
+		{"Old_Image", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"../etc", false},
+		{`a\b`, false},
+	}
+	for _, test := range tests {
+		got := SafeImageDirName(test.name)
+		if got != test.want {
+			t.Errorf("SafeImageDirName(%q) = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+// Package jsonschema checks JSON documents against the subset of JSON
+// Schema used by VMIFactory's config schemas: type, properties, required,
+// additionalProperties, items, enum, pattern, minLength and minimum.
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Problem is a place where the document does not match the schema
+type Problem struct {
+	// Path is the dotted path of the value, empty for the document itself
+	Path    string
+	Message string
+}
+
+// Validate checks the document against the schema
+func Validate(schemaData []byte, document []byte) ([]Problem, error) {
+	var schema map[string]interface{}
+	serr := json.Unmarshal(schemaData, &schema)
+	if serr != nil {
+		return nil, serr
+	}
+	var value interface{}
+	derr := json.Unmarshal(document, &value)
+	if derr != nil {
+		return nil, derr
+	}
+	problems := make([]Problem, 0)
+	check(schema, value, "", &problems)
+	return problems, nil
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func typeName(value interface{}) string {
+	switch typed := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if typed == float64(int64(typed)) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
+
+// withArticle returns the type name with "a" or "an" before it
+func withArticle(name string) string {
+	if name != "" && strings.ContainsAny(name[:1], "aeiou") {
+		return "an " + name
+	}
+	return "a " + name
+}
+
+func typeMatches(expected string, actual string) bool {
+	return expected == actual || (expected == "number" && actual == "integer")
+}
+
+func check(schema map[string]interface{}, value interface{}, path string, problems *[]Problem) {
+	add := func(message string) {
+		*problems = append(*problems, Problem{Path: path, Message: message})
+	}
+
+	actual := typeName(value)
+	switch expected := schema["type"].(type) {
+	case string:
+		if !typeMatches(expected, actual) {
+			add("must be " + withArticle(expected) + ", not " + withArticle(actual))
+			return
+		}
+	case []interface{}:
+		names := make([]string, 0, len(expected))
+		matched := false
+		for _, item := range expected {
+			name, _ := item.(string)
+			names = append(names, name)
+			matched = matched || typeMatches(name, actual)
+		}
+		if !matched {
+			add("must be one of the types " + strings.Join(names, ", ") + ", not " + withArticle(actual))
+			return
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		allowed := make([]string, 0, len(enum))
+		found := false
+		for _, item := range enum {
+			allowed = append(allowed, fmt.Sprint(item))
+			found = found || item == value
+		}
+		if !found {
+			add(fmt.Sprintf("'%v' is not one of: %s", value, strings.Join(allowed, ", ")))
+		}
+	}
+
+	switch typed := value.(type) {
+	case string:
+		if minLength, ok := schema["minLength"].(float64); ok && len(typed) < int(minLength) {
+			if minLength == 1 {
+				add("must not be empty")
+			} else {
+				add(fmt.Sprintf("must be at least %d characters", int(minLength)))
+			}
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			matched, merr := regexp.MatchString(pattern, typed)
+			if merr == nil && !matched {
+				add("'" + typed + "' does not match the pattern " + pattern)
+			}
+		}
+	case float64:
+		if minimum, ok := schema["minimum"].(float64); ok && typed < minimum {
+			add(fmt.Sprintf("must be at least %v", minimum))
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range typed {
+				check(items, item, fmt.Sprintf("%s[%d]", path, i), problems)
+			}
+		}
+	case map[string]interface{}:
+		properties, _ := schema["properties"].(map[string]interface{})
+
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, item := range required {
+				key, _ := item.(string)
+				if _, present := typed[key]; !present {
+					*problems = append(*problems, Problem{Path: joinPath(path, key), Message: "is required"})
+				}
+			}
+		}
+
+		keys := make([]string, 0, len(typed))
+		for key := range typed {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if propertySchema, ok := properties[key].(map[string]interface{}); ok {
+				check(propertySchema, typed[key], joinPath(path, key), problems)
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					*problems = append(*problems, Problem{Path: joinPath(path, key), Message: "is not a known key"})
+				}
+			case map[string]interface{}:
+				check(additional, typed[key], joinPath(path, key), problems)
+			}
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package jsonschema
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testSchema = `{
+    "type": "object",
+    "required": ["name", "source"],
+    "properties": {
+        "name": {"type": "string", "minLength": 1},
+        "port": {"type": "integer", "minimum": 1},
+        "ratio": {"type": "number"},
+        "enabled": {"type": "boolean"},
+        "mode": {"type": "string", "enum": ["hcl", "json"]},
+        "file": {"type": "string", "pattern": "^[^/]*$", "minLength": 3},
+        "env": {"type": ["object", "null"], "additionalProperties": {"type": "string"}},
+        "keys": {"type": "array", "items": {"type": "string", "minLength": 1}},
+        "source": {
+            "type": "object",
+            "required": ["hypervisor"],
+            "properties": {
+                "hypervisor": {"type": "string", "enum": ["vbox", "kvm"]}
+            },
+            "additionalProperties": false
+        }
+    },
+    "additionalProperties": false
+}`
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		want     []Problem
+	}{
+		{
+			name:     "valid",
+			document: `{"name": "a", "port": 22, "ratio": 1.5, "enabled": true, "mode": "hcl", "file": "a.ova", "env": {"A": "1"}, "keys": ["k"], "source": {"hypervisor": "kvm"}}`,
+			want:     []Problem{},
+		},
+		{
+			name:     "integer is a number",
+			document: `{"name": "a", "ratio": 2, "source": {"hypervisor": "kvm"}}`,
+			want:     []Problem{},
+		},
+		{
+			name:     "null in a type list",
+			document: `{"name": "a", "env": null, "source": {"hypervisor": "kvm"}}`,
+			want:     []Problem{},
+		},
+		{
+			name:     "required",
+			document: `{"source": {}}`,
+			want: []Problem{
+				{Path: "name", Message: "is required"},
+				{Path: "source.hypervisor", Message: "is required"},
+			},
+		},
+		{
+			name:     "type mismatches",
+			document: `{"name": 5, "port": 2.5, "enabled": "yes", "keys": "k", "source": []}`,
+			want: []Problem{
+				{Path: "enabled", Message: "must be a boolean, not a string"},
+				{Path: "keys", Message: "must be an array, not a string"},
+				{Path: "name", Message: "must be a string, not an integer"},
+				{Path: "port", Message: "must be an integer, not a number"},
+				{Path: "source", Message: "must be an object, not an array"},
+			},
+		},
+		{
+			name:     "type list mismatch",
+			document: `{"name": "a", "env": [], "source": {"hypervisor": "kvm"}}`,
+			want: []Problem{
+				{Path: "env", Message: "must be one of the types object, null, not an array"},
+			},
+		},
+		{
+			name:     "document type",
+			document: `[]`,
+			want: []Problem{
+				{Path: "", Message: "must be an object, not an array"},
+			},
+		},
+		{
+			name:     "additional properties",
+			document: `{"name": "a", "nmae": "b", "source": {"hypervisor": "kvm", "imagefile": "a.ova"}}`,
+			want: []Problem{
+				{Path: "nmae", Message: "is not a known key"},
+				{Path: "source.imagefile", Message: "is not a known key"},
+			},
+		},
+		{
+			name:     "additional properties schema",
+			document: `{"name": "a", "env": {"A": "1", "B": 2}, "source": {"hypervisor": "kvm"}}`,
+			want: []Problem{
+				{Path: "env.B", Message: "must be a string, not an integer"},
+			},
+		},
+		{
+			name:     "enum",
+			document: `{"name": "a", "mode": "yaml", "source": {"hypervisor": "vmware"}}`,
+			want: []Problem{
+				{Path: "mode", Message: "'yaml' is not one of: hcl, json"},
+				{Path: "source.hypervisor", Message: "'vmware' is not one of: vbox, kvm"},
+			},
+		},
+		{
+			name:     "string and number limits",
+			document: `{"name": "", "port": 0, "file": "a/", "source": {"hypervisor": "kvm"}}`,
+			want: []Problem{
+				{Path: "file", Message: "must be at least 3 characters"},
+				{Path: "file", Message: "'a/' does not match the pattern ^[^/]*$"},
+				{Path: "name", Message: "must not be empty"},
+				{Path: "port", Message: "must be at least 1"},
+			},
+		},
+		{
+			name:     "array items",
+			document: `{"name": "a", "keys": ["k", "", 3], "source": {"hypervisor": "kvm"}}`,
+			want: []Problem{
+				{Path: "keys[1]", Message: "must not be empty"},
+				{Path: "keys[2]", Message: "must be a string, not an integer"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			problems, err := Validate([]byte(testSchema), []byte(test.document))
+			if err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			if !reflect.DeepEqual(problems, test.want) {
+				t.Errorf("Validate() = %+v, want %+v", problems, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateInvalidJSON(t *testing.T) {
+	_, err := Validate([]byte(testSchema), []byte(`{"name": `))
+	if err == nil {
+		t.Error("Validate() of invalid JSON succeeded")
+	}
+	_, err = Validate([]byte(`{`), []byte(`{}`))
+	if err == nil {
+		t.Error("Validate() with an invalid schema succeeded")
+	}
+}
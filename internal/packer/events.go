@@ -0,0 +1,137 @@
+package packer
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EventType is the kind of progress event reported by a Packer build
+type EventType int
+
+const (
+	// EventMessage is any other UI output
+	EventMessage EventType = iota
+	// EventBuilderStarted is sent when a builder starts
+	EventBuilderStarted
+	// EventProvisioner is sent when a provisioning step starts
+	EventProvisioner
+	// EventArtifact is sent for each file of a produced artifact
+	EventArtifact
+	// EventError is sent for errors reported by Packer
+	EventError
+)
+
+func (e EventType) String() string {
+	switch e {
+	case EventBuilderStarted:
+		return "builder-started"
+	case EventProvisioner:
+		return "provisioner"
+	case EventArtifact:
+		return "artifact"
+	case EventError:
+		return "error"
+	}
+	return "message"
+}
+
+// Event is a progress event parsed from Packer's machine-readable output
+type Event struct {
+	Type    EventType
+	Time    time.Time
+	Builder string
+	Message string
+	// Current and Total are set for provisioner events
+	Current int
+	Total   int
+	// Artifact is the file path for artifact events
+	Artifact string
+}
+
+var colorRegex = regexp.MustCompile(`^([^\s:]+): output will be in this color`)
+var prefixRegex = regexp.MustCompile(`^(?:==> |    )?([^\s:]+): (.*)$`)
+
+// EventParser turns machine-readable output lines into events. It
+// counts provisioning steps, so one parser should be used per build.
+type EventParser struct {
+	// TotalSteps is the number of provisioning steps expected
+	TotalSteps int
+	current    int
+}
+
+// unescape reverses Packer's escaping of machine-readable data fields
+func unescape(field string) string {
+	field = strings.ReplaceAll(field, "%!(PACKER_COMMA)", ",")
+	field = strings.ReplaceAll(field, "\\r", "\r")
+	field = strings.ReplaceAll(field, "\\n", "\n")
+	return field
+}
+
+// Parse parses a single line of machine-readable output. The second
+// return value is false if the line is not a machine-readable record.
+func (p *EventParser) Parse(line string) (Event, bool) {
+	fields := strings.Split(strings.TrimRight(line, "\r\n"), ",")
+	if len(fields) < 3 {
+		return Event{}, false
+	}
+	timestamp, terr := strconv.ParseInt(fields[0], 10, 64)
+	if terr != nil {
+		return Event{}, false
+	}
+
+	event := Event{
+		Type:    EventMessage,
+		Time:    time.Unix(timestamp, 0),
+		Builder: fields[1],
+	}
+	data := make([]string, len(fields)-3)
+	for i := range data {
+		data[i] = unescape(fields[i+3])
+	}
+
+	switch fields[2] {
+	case "ui":
+		if len(data) < 2 {
+			return event, true
+		}
+		event.Message = data[1]
+		if data[0] == "error" {
+			event.Type = EventError
+			return event, true
+		}
+		match := colorRegex.FindStringSubmatch(event.Message)
+		if match != nil {
+			event.Type = EventBuilderStarted
+			event.Builder = match[1]
+			return event, true
+		}
+		match = prefixRegex.FindStringSubmatch(event.Message)
+		if match != nil {
+			if event.Builder == "" {
+				event.Builder = match[1]
+			}
+			if strings.HasPrefix(match[2], "Provisioning with ") {
+				p.current++
+				event.Type = EventProvisioner
+				event.Current = p.current
+				event.Total = p.TotalSteps
+			}
+		}
+	case "artifact":
+		// <index>,file,<file index>,<path>
+		if len(data) >= 4 && data[1] == "file" {
+			event.Type = EventArtifact
+			event.Artifact = data[3]
+			event.Message = data[3]
+		}
+	case "error":
+		if len(data) >= 1 {
+			event.Type = EventError
+			event.Message = data[0]
+		}
+	}
+
+	return event, true
+}
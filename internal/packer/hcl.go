@@ -0,0 +1,186 @@
+package packer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// hclString quotes a string for HCL, escaping template sequences
+func hclString(value string) string {
+	var str strings.Builder
+	str.WriteString("\"")
+	hclEscape(&str, value)
+	str.WriteString("\"")
+	return str.String()
+}
+
+// hclEscape writes a string's characters escaped for an HCL quoted string
+func hclEscape(str *strings.Builder, value string) {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '"':
+			str.WriteString("\\\"")
+		case c == '\\':
+			str.WriteString("\\\\")
+		case c == '\n':
+			str.WriteString("\\n")
+		case c == '\r':
+			str.WriteString("\\r")
+		case c == '\t':
+			str.WriteString("\\t")
+		case (c == '$' || c == '%') && i+1 < len(value) && value[i+1] == '{':
+			// Double the marker so HCL does not treat it as interpolation
+			str.WriteByte(c)
+			str.WriteByte(c)
+		default:
+			str.WriteByte(c)
+		}
+	}
+}
+
+// hclInterpolation quotes an interpolation for HCL, the variables as ${var.name}
+func hclInterpolation(parts Interpolation) string {
+	var str strings.Builder
+	str.WriteString("\"")
+	for _, part := range parts {
+		switch typed := part.(type) {
+		case VarRef:
+			str.WriteString("${var." + string(typed) + "}")
+		case string:
+			hclEscape(&str, typed)
+		}
+	}
+	str.WriteString("\"")
+	return str.String()
+}
+
+func hclValue(value interface{}, indent string) (string, error) {
+	switch typed := value.(type) {
+	case string:
+		return hclString(typed), nil
+	case VarRef:
+		return "var." + string(typed), nil
+	case Interpolation:
+		return hclInterpolation(typed), nil
+	case bool:
+		return strconv.FormatBool(typed), nil
+	case int:
+		return strconv.Itoa(typed), nil
+	case []string:
+		if len(typed) == 0 {
+			return "[]", nil
+		}
+		var str strings.Builder
+		str.WriteString("[\n")
+		for _, item := range typed {
+			str.WriteString(indent + "  " + hclString(item) + ",\n")
+		}
+		str.WriteString(indent + "]")
+		return str.String(), nil
+	case []interface{}:
+		if len(typed) == 0 {
+			return "[]", nil
+		}
+		var str strings.Builder
+		str.WriteString("[\n")
+		for _, item := range typed {
+			itemValue, err := hclValue(item, indent+"  ")
+			if err != nil {
+				return "", err
+			}
+			str.WriteString(indent + "  " + itemValue + ",\n")
+		}
+		str.WriteString(indent + "]")
+		return str.String(), nil
+	case map[string]string:
+		keys := make([]string, 0, len(typed))
+		for key := range typed {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		var str strings.Builder
+		str.WriteString("{\n")
+		for _, key := range keys {
+			str.WriteString(indent + "  " + hclString(key) + " = " + hclString(typed[key]) + "\n")
+		}
+		str.WriteString(indent + "}")
+		return str.String(), nil
+	}
+	return "", fmt.Errorf("Unsupported Packer setting type %T", value)
+}
+
+// writeHCLSettings writes the settings as attributes, sorted and aligned like 'packer fmt'
+func writeHCLSettings(str *strings.Builder, settings Settings, indent string) error {
+	keys := make([]string, 0, len(settings))
+	width := 0
+	for key := range settings {
+		keys = append(keys, key)
+		if len(key) > width {
+			width = len(key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value, err := hclValue(settings[key], indent)
+		if err != nil {
+			return errors.New("Setting '" + key + "': " + err.Error())
+		}
+		str.WriteString(indent + key + strings.Repeat(" ", width-len(key)) + " = " + value + "\n")
+	}
+	return nil
+}
+
+// RenderHCL writes the template as a Packer HCL2 template
+func (t *Template) RenderHCL() (string, error) {
+	var str strings.Builder
+
+	if len(t.RequiredPlugins) > 0 {
+		str.WriteString("packer {\n  required_plugins {\n")
+		for _, plugin := range t.RequiredPlugins {
+			str.WriteString("    " + plugin.Name + " = {\n")
+			str.WriteString("      source  = " + hclString(plugin.Source) + "\n")
+			str.WriteString("      version = " + hclString(plugin.Version) + "\n")
+			str.WriteString("    }\n")
+		}
+		str.WriteString("  }\n}\n\n")
+	}
+
+	for _, variable := range t.Variables {
+		str.WriteString("variable " + hclString(variable.Name) + " {\n")
+		str.WriteString("  type      = string\n")
+		str.WriteString("  sensitive = " + strconv.FormatBool(variable.Sensitive) + "\n")
+		str.WriteString("}\n\n")
+	}
+
+	sourceNames := make([]string, len(t.Sources))
+	for i, source := range t.Sources {
+		str.WriteString("source " + hclString(source.Type) + " " + hclString(source.Name) + " {\n")
+		err := writeHCLSettings(&str, source.Settings, "  ")
+		if err != nil {
+			return "", errors.New("Source '" + source.Name + "': " + err.Error())
+		}
+		str.WriteString("}\n\n")
+		sourceNames[i] = "source." + source.Type + "." + source.Name
+	}
+
+	str.WriteString("build {\n")
+	sourceList, _ := hclValue(sourceNames, "  ")
+	str.WriteString("  sources = " + sourceList + "\n")
+
+	for _, provisioner := range t.Provisioners {
+		str.WriteString("\n  provisioner " + hclString(provisioner.Type) + " {\n")
+		err := writeHCLSettings(&str, provisioner.Settings, "    ")
+		if err != nil {
+			return "", errors.New("Provisioner '" + provisioner.Type + "': " + err.Error())
+		}
+		str.WriteString("  }\n")
+	}
+	str.WriteString("}\n")
+
+	return str.String(), nil
+}
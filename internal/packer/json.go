@@ -0,0 +1,71 @@
+package packer
+
+import "encoding/json"
+
+// jsonValue converts variable references to the legacy JSON template syntax
+func jsonValue(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case VarRef:
+		return jsonInterpolation(Interpolation{typed})
+	case Interpolation:
+		return jsonInterpolation(typed)
+	case []interface{}:
+		items := make([]interface{}, len(typed))
+		for i, item := range typed {
+			items[i] = jsonValue(item)
+		}
+		return items
+	}
+	return value
+}
+
+func jsonSettings(typeName string, settings Settings) map[string]interface{} {
+	out := make(map[string]interface{})
+	for key, value := range settings {
+		out[key] = jsonValue(value)
+	}
+	out["type"] = typeName
+	return out
+}
+
+// RenderJSON writes the template as a legacy Packer JSON template.
+// Required plugins are not supported by the format and are left out.
+// Variables default to their environment variables, like HCL templates.
+func (t *Template) RenderJSON() (string, error) {
+	fullConfig := make(map[string]interface{})
+
+	if len(t.Variables) > 0 {
+		variables := make(map[string]string)
+		sensitive := make([]string, 0)
+		for _, variable := range t.Variables {
+			variables[variable.Name] = "{{env `" + VariableEnv(variable.Name) + "`}}"
+			if variable.Sensitive {
+				sensitive = append(sensitive, variable.Name)
+			}
+		}
+		fullConfig["variables"] = variables
+		if len(sensitive) > 0 {
+			fullConfig["sensitive-variables"] = sensitive
+		}
+	}
+
+	builders := make([]interface{}, 0, len(t.Sources))
+	for _, source := range t.Sources {
+		builderOut := jsonSettings(source.Type, source.Settings)
+		builderOut["name"] = source.Name
+		builders = append(builders, builderOut)
+	}
+	fullConfig["builders"] = builders
+
+	provisioners := make([]interface{}, 0, len(t.Provisioners))
+	for _, provisioner := range t.Provisioners {
+		provisioners = append(provisioners, jsonSettings(provisioner.Type, provisioner.Settings))
+	}
+	if len(provisioners) > 0 {
+		fullConfig["provisioners"] = provisioners
+	}
+
+	outJSONBytes, oerr := json.MarshalIndent(fullConfig, "", "    ")
+
+	return string(outJSONBytes), oerr
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+
+package packer
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts Packer in its own process group, so it and the
+// hypervisor processes it starts can be stopped together
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// interruptProcessGroup asks Packer to stop and clean up its VMs
+func interruptProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGINT)
+}
+
+// killProcessGroup kills Packer and everything it started
+func killProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+package packer
+
+import "os/exec"
+
+// setProcessGroup does nothing on Windows
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// interruptProcessGroup stops Packer, Windows has no interrupt signal to send
+func interruptProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+// killProcessGroup kills Packer
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
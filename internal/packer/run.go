@@ -0,0 +1,219 @@
+package packer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// readLines calls handle for each line of a pipe until it is closed.
+// Lines longer than maxLineSize are cut short and marked, the rest of
+// the line is skipped, so one huge line does not stop the output.
+func readLines(logger *log.Logger, name string, pipe io.Reader, handle func(string)) {
+	reader := bufio.NewReaderSize(pipe, 64*1024)
+	line := make([]byte, 0, 64*1024)
+	truncated := false
+	for {
+		chunk, isPrefix, rerr := reader.ReadLine()
+		if rerr != nil {
+			if rerr != io.EOF {
+				logger.Println("Could not read Packer " + name + ": " + rerr.Error())
+			}
+			return
+		}
+		room := maxLineSize - len(line)
+		if len(chunk) > room {
+			chunk = chunk[:room]
+			truncated = true
+		}
+		line = append(line, chunk...)
+		if isPrefix {
+			continue
+		}
+		text := string(line)
+		if truncated {
+			text += truncatedMarker
+		}
+		handle(text)
+		line = line[:0]
+		truncated = false
+	}
+}
+
+// Runner runs Packer builds, streaming the output as it arrives
+type Runner struct {
+	// PackerPath is the path to the Packer executable
+	PackerPath string
+	// LogWriter receives every line Packer outputs
+	LogWriter io.Writer
+	// Logger gets Packer's UI output, the standard logger if nil
+	Logger *log.Logger
+	// OnEvent is called for each progress event, may be nil
+	OnEvent func(Event)
+	// TotalSteps is the number of provisioning steps in the template
+	TotalSteps int
+	// Env is added to the environment Packer runs with
+	Env []string
+	// Secrets are replaced with "<sensitive>" in everything logged
+	Secrets []string
+	// StopTimeout is how long Packer gets to clean up when cancelled
+	// before it is killed, defaults to DefaultStopTimeout
+	StopTimeout time.Duration
+}
+
+// DefaultStopTimeout is the default time Packer gets to clean up when cancelled
+const DefaultStopTimeout = time.Minute
+
+const maxLineSize = 1024 * 1024
+
+// truncatedMarker ends lines cut short at maxLineSize
+const truncatedMarker = " [line truncated]"
+
+func (r Runner) logger() *log.Logger {
+	if r.Logger == nil {
+		return log.Default()
+	}
+	return r.Logger
+}
+
+// scrub replaces the secrets in Packer's output, including the form
+// they take in machine-readable output
+func (r Runner) scrub(text string) string {
+	for _, secret := range r.Secrets {
+		if secret == "" {
+			continue
+		}
+		text = strings.ReplaceAll(text, secret, "<sensitive>")
+		text = strings.ReplaceAll(text, strings.ReplaceAll(secret, ",", "%!(PACKER_COMMA)"), "<sensitive>")
+	}
+	return text
+}
+
+// Init runs 'packer init' on the template file to install its required plugins
+func (r Runner) Init(ctx context.Context, configFile string) error {
+	cmd := exec.CommandContext(ctx, r.PackerPath, "init", configFile)
+	cmd.Env = append(os.Environ(), r.Env...)
+	rawOutput, err := cmd.CombinedOutput()
+	output := r.scrub(string(rawOutput))
+	if r.LogWriter != nil {
+		io.WriteString(r.LogWriter, output)
+	}
+	r.logger().Print(output)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// Build runs 'packer build' on the template file. If the context is
+// cancelled, Packer is interrupted, then killed after StopTimeout.
+func (r Runner) Build(ctx context.Context, configFile string) error {
+	cmd := exec.Command(r.PackerPath, "build", "-machine-readable", configFile)
+	cmd.Env = append(os.Environ(), r.Env...)
+	setProcessGroup(cmd)
+
+	stdout, perr := cmd.StdoutPipe()
+	if perr != nil {
+		return perr
+	}
+	stderr, perr := cmd.StderrPipe()
+	if perr != nil {
+		return perr
+	}
+
+	serr := cmd.Start()
+	if serr != nil {
+		return serr
+	}
+
+	stopTimeout := r.StopTimeout
+	if stopTimeout == 0 {
+		stopTimeout = DefaultStopTimeout
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			r.logger().Println("Stopping Packer build...")
+			interruptProcessGroup(cmd)
+			select {
+			case <-done:
+			case <-time.After(stopTimeout):
+				r.logger().Println("Packer did not stop, killing it...")
+				killProcessGroup(cmd)
+			}
+		case <-done:
+		}
+	}()
+
+	var logLock sync.Mutex
+	writeLog := func(line string) {
+		if r.LogWriter == nil {
+			return
+		}
+		logLock.Lock()
+		defer logLock.Unlock()
+		io.WriteString(r.LogWriter, line+"\n")
+	}
+
+	parser := EventParser{TotalSteps: r.TotalSteps}
+	lastError := ""
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		readLines(r.logger(), "stdout", stdout, func(rawLine string) {
+			line := r.scrub(rawLine)
+			writeLog(line)
+			event, ok := parser.Parse(line)
+			if !ok {
+				r.logger().Println(line)
+				return
+			}
+			if event.Message != "" && event.Type != EventArtifact {
+				for _, msgLine := range strings.Split(strings.TrimRight(event.Message, "\n"), "\n") {
+					r.logger().Println(msgLine)
+				}
+			}
+			if event.Type == EventError {
+				lastError = event.Message
+			}
+			if r.OnEvent != nil {
+				r.OnEvent(event)
+			}
+		})
+	}()
+
+	go func() {
+		defer wg.Done()
+		readLines(r.logger(), "stderr", stderr, func(rawLine string) {
+			line := r.scrub(rawLine)
+			writeLog(line)
+			r.logger().Println("(stderr) " + line)
+		})
+	}()
+
+	// The pipes have to be drained before waiting on the process
+	wg.Wait()
+	werr := cmd.Wait()
+	close(done)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if werr != nil {
+		if lastError != "" {
+			return errors.New("Packer build failed: " + strings.TrimSpace(lastError))
+		}
+		return werr
+	}
+	return nil
+}
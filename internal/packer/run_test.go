@@ -0,0 +1,77 @@
+package packer
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildKeepsReadingAfterLongLines(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	// The 2 MB lines are over maxLineSize, the 4 MB after them would fill
+	// the pipes if they were not read
+	script := "#!/bin/sh\n" +
+		"head -c 2097152 /dev/zero | tr '\\0' x\n" +
+		"echo\n" +
+		"head -c 2097152 /dev/zero | tr '\\0' z >&2\n" +
+		"echo >&2\n" +
+		"head -c 4194304 /dev/zero | tr '\\0' y\n" +
+		"echo\n" +
+		"echo 'stderr after the long line' >&2\n" +
+		"echo '1700000000,,ui,error,Build failed after the long line'\n" +
+		"exit 1\n"
+	packerPath := t.TempDir() + "/packer"
+	werr := ioutil.WriteFile(packerPath, []byte(script), 0755)
+	if werr != nil {
+		t.Fatal(werr)
+	}
+
+	var logOutput bytes.Buffer
+	var events []Event
+	runner := Runner{
+		PackerPath: packerPath,
+		LogWriter:  &logOutput,
+		Logger:     log.New(ioutil.Discard, "", 0),
+		OnEvent:    func(event Event) { events = append(events, event) },
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	berr := runner.Build(ctx, "template.pkr.hcl")
+	if berr == nil || !strings.Contains(berr.Error(), "Build failed after the long line") {
+		t.Errorf("Build() = %v, want the error reported after the long line", berr)
+	}
+
+	lines := strings.Split(strings.TrimRight(logOutput.String(), "\n"), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("log has %d lines, want 5", len(lines))
+	}
+	wantLong := strings.Repeat("x", maxLineSize) + truncatedMarker
+	found := false
+	for _, line := range lines {
+		if line == wantLong {
+			found = true
+		}
+		if len(line) > maxLineSize+len(truncatedMarker) {
+			t.Errorf("log has a line of %d bytes, want at most %d", len(line), maxLineSize+len(truncatedMarker))
+		}
+	}
+	if !found {
+		t.Error("log does not have the long line cut short and marked")
+	}
+	for _, want := range []string{"stderr after the long line", "1700000000,,ui,error,Build failed after the long line"} {
+		if !strings.Contains(logOutput.String(), want+"\n") {
+			t.Errorf("log does not have %q", want)
+		}
+	}
+
+	if len(events) != 1 || events[0].Type != EventError || events[0].Message != "Build failed after the long line" {
+		t.Errorf("events = %+v, want the error after the long line", events)
+	}
+}
@@ -0,0 +1,86 @@
+package packer
+
+import "errors"
+
+// Formats a template can be rendered as
+const (
+	FormatHCL  = "hcl"
+	FormatJSON = "json"
+)
+
+// Settings are the attributes of a source or provisioner. Values may be
+// a string, bool, int, []string, map[string]string, VarRef or Interpolation,
+// or a []interface{} of strings, VarRefs and Interpolations.
+type Settings map[string]interface{}
+
+// Plugin is an external plugin required by the template
+type Plugin struct {
+	Name    string
+	Source  string
+	Version string
+}
+
+// Source is a Packer builder
+type Source struct {
+	Type     string
+	Name     string
+	Settings Settings
+}
+
+// Provisioner is a Packer provisioner, run in order after the source is up
+type Provisioner struct {
+	Type     string
+	Settings Settings
+}
+
+// Template is a Packer template independent of the format it is written in
+type Template struct {
+	RequiredPlugins []Plugin
+	Variables       []Variable
+	Sources         []Source
+	Provisioners    []Provisioner
+}
+
+// AddPlugin adds a required plugin if it has not already been added
+func (t *Template) AddPlugin(plugin Plugin) {
+	for _, existing := range t.RequiredPlugins {
+		if existing.Name == plugin.Name {
+			return
+		}
+	}
+	t.RequiredPlugins = append(t.RequiredPlugins, plugin)
+}
+
+// Render writes the template in the given format
+func (t *Template) Render(format string) (string, error) {
+	switch format {
+	case FormatHCL:
+		return t.RenderHCL()
+	case FormatJSON:
+		return t.RenderJSON()
+	}
+	return "", errors.New("Unknown Packer template format '" + format + "'")
+}
+
+// FileName returns the name the template file should have for the given format
+func FileName(base string, format string) string {
+	if format == FormatJSON {
+		return base + ".json"
+	}
+	return base + ".pkr.hcl"
+}
+
+// ProvisionerSteps returns the number of provisioning steps Packer will
+// report, counting each script of a script provisioner as a step
+func (t *Template) ProvisionerSteps() int {
+	steps := 0
+	for _, provisioner := range t.Provisioners {
+		scripts, ok := provisioner.Settings["scripts"].([]string)
+		if ok {
+			steps += len(scripts)
+		} else {
+			steps++
+		}
+	}
+	return steps
+}
@@ -0,0 +1,47 @@
+package packer
+
+import "strings"
+
+// Variable is an input variable of the template. Its value is passed to
+// Packer in the environment, see VariableEnv, so it is never written to
+// the template file.
+type Variable struct {
+	Name string
+	// Sensitive variables are masked in Packer's output
+	Sensitive bool
+}
+
+// VarRef is a setting value that is the value of a variable
+type VarRef string
+
+// Interpolation is a string setting made of literal strings and VarRef parts
+type Interpolation []interface{}
+
+// VariableEnv returns the environment variable Packer reads a variable's value from
+func VariableEnv(name string) string {
+	return "PKR_VAR_" + name
+}
+
+// AddVariable adds an input variable if it has not already been added
+func (t *Template) AddVariable(variable Variable) {
+	for _, existing := range t.Variables {
+		if existing.Name == variable.Name {
+			return
+		}
+	}
+	t.Variables = append(t.Variables, variable)
+}
+
+// jsonInterpolation writes an interpolation in the legacy JSON template syntax
+func jsonInterpolation(parts Interpolation) string {
+	var str strings.Builder
+	for _, part := range parts {
+		switch typed := part.(type) {
+		case VarRef:
+			str.WriteString("{{user `" + string(typed) + "`}}")
+		case string:
+			str.WriteString(typed)
+		}
+	}
+	return str.String()
+}
@@ -0,0 +1,178 @@
+package schedule
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule decides when an image is next rebuilt
+type Schedule interface {
+	// Next returns the next run time after the given time
+	Next(after time.Time) time.Time
+}
+
+// intervalSchedule runs at a fixed interval after the last run
+type intervalSchedule struct {
+	interval time.Duration
+}
+
+func (s intervalSchedule) Next(after time.Time) time.Time {
+	return after.Add(s.interval)
+}
+
+// cronSchedule runs at the times matching a cron expression
+type cronSchedule struct {
+	minutes  []bool
+	hours    []bool
+	days     []bool
+	months   []bool
+	weekdays []bool
+	// Like cron, if both days and weekdays are restricted either may match
+	daysStar     bool
+	weekdaysStar bool
+}
+
+var shortcuts = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+// Parse parses a schedule. It may be a five field cron expression
+// ("minute hour day-of-month month day-of-week"), a shortcut like
+// "@daily" or "@weekly", or an interval like "@every 36h".
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@every ") {
+		interval, err := time.ParseDuration(strings.TrimSpace(spec[len("@every "):]))
+		if err != nil {
+			return nil, errors.New("Invalid schedule interval in '" + spec + "'")
+		}
+		if interval < time.Minute {
+			return nil, errors.New("Schedule interval in '" + spec + "' is shorter than a minute")
+		}
+		return intervalSchedule{interval: interval}, nil
+	}
+	expanded, ok := shortcuts[spec]
+	if ok {
+		spec = expanded
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, errors.New("Schedule '" + spec + "' must be a cron expression with 5 fields, a shortcut or '@every <interval>'")
+	}
+
+	var err error
+	schedule := cronSchedule{
+		daysStar:     fields[2] == "*",
+		weekdaysStar: fields[4] == "*",
+	}
+	if schedule.minutes, err = parseField(fields[0], 0, 59); err != nil {
+		return nil, errors.New("Schedule minute: " + err.Error())
+	}
+	if schedule.hours, err = parseField(fields[1], 0, 23); err != nil {
+		return nil, errors.New("Schedule hour: " + err.Error())
+	}
+	if schedule.days, err = parseField(fields[2], 1, 31); err != nil {
+		return nil, errors.New("Schedule day of month: " + err.Error())
+	}
+	if schedule.months, err = parseField(fields[3], 1, 12); err != nil {
+		return nil, errors.New("Schedule month: " + err.Error())
+	}
+	if schedule.weekdays, err = parseField(fields[4], 0, 7); err != nil {
+		return nil, errors.New("Schedule day of week: " + err.Error())
+	}
+	// Both 0 and 7 are Sunday
+	if schedule.weekdays[7] {
+		schedule.weekdays[0] = true
+	}
+	return schedule, nil
+}
+
+// parseField parses a comma separated list of '*', values, ranges and
+// steps into a table of which values from min to max match
+func parseField(field string, min int, max int) ([]bool, error) {
+	matches := make([]bool, max+1)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		slash := strings.Index(part, "/")
+		if slash != -1 {
+			var err error
+			step, err = strconv.Atoi(part[slash+1:])
+			if err != nil || step < 1 {
+				return nil, errors.New("Invalid step in '" + part + "'")
+			}
+			part = part[:slash]
+		}
+
+		start, end := min, max
+		if part != "*" {
+			dash := strings.Index(part, "-")
+			var serr, eerr error
+			if dash == -1 {
+				start, serr = strconv.Atoi(part)
+				end = start
+				if slash != -1 {
+					end = max
+				}
+			} else {
+				start, serr = strconv.Atoi(part[:dash])
+				end, eerr = strconv.Atoi(part[dash+1:])
+			}
+			if serr != nil || eerr != nil {
+				return nil, errors.New("Invalid value '" + part + "'")
+			}
+			if start < min || end > max || start > end {
+				return nil, errors.New("Value '" + part + "' out of range " + strconv.Itoa(min) + "-" + strconv.Itoa(max))
+			}
+		}
+
+		for value := start; value <= end; value += step {
+			matches[value] = true
+		}
+	}
+	return matches, nil
+}
+
+func (s cronSchedule) dayMatches(t time.Time) bool {
+	dayMatch := s.days[t.Day()]
+	weekdayMatch := s.weekdays[int(t.Weekday())]
+	if !s.daysStar && !s.weekdaysStar {
+		return dayMatch || weekdayMatch
+	}
+	return dayMatch && weekdayMatch
+}
+
+func (s cronSchedule) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	// Give up after five years, an expression like "0 0 30 2 *" never matches
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if !s.months[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.hours[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.minutes[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
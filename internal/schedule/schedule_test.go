@@ -0,0 +1,180 @@
+package schedule
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustTime(t *testing.T, value string) time.Time {
+	t.Helper()
+	parsed, err := time.Parse("2006-01-02 15:04", value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		spec  string
+		after string
+		want  string
+	}{
+		// Fields, ranges, lists and steps
+		{"30 2 * * *", "2026-03-10 01:00", "2026-03-10 02:30"},
+		{"30 2 * * *", "2026-03-10 02:30", "2026-03-11 02:30"},
+		{"*/15 * * * *", "2026-03-10 10:07", "2026-03-10 10:15"},
+		{"*/15 * * * *", "2026-03-10 10:45", "2026-03-10 11:00"},
+		{"5/20 * * * *", "2026-03-10 10:26", "2026-03-10 10:45"},
+		{"0 9-17/4 * * *", "2026-03-10 14:00", "2026-03-10 17:00"},
+		{"0 9-17/4 * * *", "2026-03-10 17:00", "2026-03-11 09:00"},
+		{"0 8,20 * * *", "2026-03-10 08:00", "2026-03-10 20:00"},
+		{"0 0 * 2,8 *", "2026-03-10 00:00", "2026-08-01 00:00"},
+		// Seconds are dropped, the next run is always later
+		{"* * * * *", "2026-03-10 10:07", "2026-03-10 10:08"},
+
+		// Shortcuts
+		{"@hourly", "2026-03-10 10:07", "2026-03-10 11:00"},
+		{"@daily", "2026-03-10 10:07", "2026-03-11 00:00"},
+		{"@midnight", "2026-03-10 10:07", "2026-03-11 00:00"},
+		{"@weekly", "2026-03-10 10:07", "2026-03-15 00:00"},
+		{"@monthly", "2026-03-10 10:07", "2026-04-01 00:00"},
+		{"@yearly", "2026-03-10 10:07", "2027-01-01 00:00"},
+		{"@annually", "2026-03-10 10:07", "2027-01-01 00:00"},
+
+		// Month ends
+		{"0 0 31 * *", "2026-04-01 00:00", "2026-05-31 00:00"},
+		{"0 0 30 * *", "2026-01-31 00:00", "2026-03-30 00:00"},
+		{"0 0 29 2 *", "2026-01-01 00:00", "2028-02-29 00:00"},
+		{"59 23 * * *", "2026-12-31 23:59", "2027-01-01 23:59"},
+		{"0 0 1 * *", "2026-12-15 00:00", "2027-01-01 00:00"},
+
+		// Days of the week, 0 and 7 are Sunday
+		{"0 0 * * 1", "2026-03-10 00:00", "2026-03-16 00:00"},
+		{"0 0 * * 7", "2026-03-10 00:00", "2026-03-15 00:00"},
+		{"0 0 * * 0", "2026-03-10 00:00", "2026-03-15 00:00"},
+		{"0 0 * * 1-5", "2026-03-13 12:00", "2026-03-16 00:00"},
+		// With both restricted either matches: the 1st or a Friday
+		{"0 0 1 * 5", "2026-03-02 00:00", "2026-03-06 00:00"},
+		{"0 0 1 * 5", "2026-03-27 00:00", "2026-04-01 00:00"},
+		// With one restricted only it counts
+		{"0 0 13 * *", "2026-03-10 00:00", "2026-03-13 00:00"},
+		{"0 0 * * 5", "2026-03-01 00:00", "2026-03-06 00:00"},
+		{"0 0 13 * 5", "2026-02-14 00:00", "2026-02-20 00:00"},
+
+		// Never matches
+		{"0 0 30 2 *", "2026-01-01 00:00", ""},
+	}
+	for _, test := range tests {
+		schedule, err := Parse(test.spec)
+		if err != nil {
+			t.Errorf("Parse(%q) error = %v", test.spec, err)
+			continue
+		}
+		got := schedule.Next(mustTime(t, test.after))
+		if test.want == "" {
+			if !got.IsZero() {
+				t.Errorf("Parse(%q).Next(%s) = %s, want never", test.spec, test.after, got.Format("2006-01-02 15:04"))
+			}
+			continue
+		}
+		if !got.Equal(mustTime(t, test.want)) {
+			t.Errorf("Parse(%q).Next(%s) = %s, want %s", test.spec, test.after, got.Format("2006-01-02 15:04"), test.want)
+		}
+	}
+}
+
+func TestNextEvery(t *testing.T) {
+	schedule, err := Parse("@every 36h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := mustTime(t, "2026-03-10 10:07")
+	got := schedule.Next(after)
+	if !got.Equal(after.Add(36 * time.Hour)) {
+		t.Errorf("Next(%s) = %s, want 36h later", after, got)
+	}
+}
+
+func TestNextKeepsLocation(t *testing.T) {
+	location := time.FixedZone("UTC+5", 5*60*60)
+	schedule, err := Parse("@daily")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := schedule.Next(time.Date(2026, 3, 10, 23, 30, 0, 0, location))
+	want := time.Date(2026, 3, 11, 0, 0, 0, 0, location)
+	if !got.Equal(want) || got.Location() != location {
+		t.Errorf("Next() = %s, want %s", got, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"", "must be a cron expression with 5 fields"},
+		{"0 0 * *", "must be a cron expression with 5 fields"},
+		{"0 0 * * * *", "must be a cron expression with 5 fields"},
+		{"@fortnightly", "must be a cron expression with 5 fields"},
+		{"60 * * * *", "Schedule minute: Value '60' out of range 0-59"},
+		{"* 24 * * *", "Schedule hour: Value '24' out of range 0-23"},
+		{"* * 0 * *", "Schedule day of month: Value '0' out of range 1-31"},
+		{"* * * 13 *", "Schedule month: Value '13' out of range 1-12"},
+		{"* * * * 8", "Schedule day of week: Value '8' out of range 0-7"},
+		{"* 5-2 * * *", "Schedule hour: Value '5-2' out of range 0-23"},
+		{"*/0 * * * *", "Schedule minute: Invalid step in '*/0'"},
+		{"*/x * * * *", "Schedule minute: Invalid step in '*/x'"},
+		{"a * * * *", "Schedule minute: Invalid value 'a'"},
+		{"1-b * * * *", "Schedule minute: Invalid value '1-b'"},
+		{"@every soon", "Invalid schedule interval"},
+		{"@every 30s", "shorter than a minute"},
+	}
+	for _, test := range tests {
+		_, err := Parse(test.spec)
+		if err == nil {
+			t.Errorf("Parse(%q) succeeded, want error containing %q", test.spec, test.want)
+		} else if !strings.Contains(err.Error(), test.want) {
+			t.Errorf("Parse(%q) error = %q, want it to contain %q", test.spec, err, test.want)
+		}
+	}
+}
+
+func TestTable(t *testing.T) {
+	path := t.TempDir() + "/schedule.json"
+	table, lerr := LoadTable(path)
+	if lerr != nil {
+		t.Fatalf("LoadTable() of a missing file error = %v", lerr)
+	}
+	if table.Get("image") != nil {
+		t.Error("Get() on an empty table is not nil")
+	}
+
+	next := mustTime(t, "2026-03-11 00:00")
+	table.Set("image", Entry{Spec: "@daily", NextRun: next})
+	entry := table.Get("image")
+	entry.Spec = "changed"
+	if table.Get("image").Spec != "@daily" {
+		t.Error("changing the entry from Get() changed the table")
+	}
+	table.Set("removed", Entry{Spec: "@hourly"})
+	table.Remove("removed")
+
+	serr := table.Save()
+	if serr != nil {
+		t.Fatalf("Save() error = %v", serr)
+	}
+	loaded, lerr := LoadTable(path)
+	if lerr != nil {
+		t.Fatalf("LoadTable() error = %v", lerr)
+	}
+	if len(loaded.Entries) != 1 {
+		t.Fatalf("loaded %d entries, want 1", len(loaded.Entries))
+	}
+	got := loaded.Get("image")
+	if got == nil || got.Spec != "@daily" || !got.NextRun.Equal(next) {
+		t.Errorf("loaded entry = %+v, want the saved one", got)
+	}
+}
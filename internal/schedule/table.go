@@ -0,0 +1,78 @@
+package schedule
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bocajspear1/vmifactory/internal/helpers"
+)
+
+// Entry is the run record of one image
+type Entry struct {
+	LastRun time.Time `json:"last_run"`
+	NextRun time.Time `json:"next_run"`
+	Spec    string    `json:"schedule"`
+}
+
+// Table is the persisted table of next run times, keyed by image name
+type Table struct {
+	path    string
+	lock    sync.Mutex
+	Entries map[string]*Entry
+}
+
+// LoadTable loads the table from a file, an empty table if it does not exist yet
+func LoadTable(path string) (*Table, error) {
+	table := &Table{path: path, Entries: make(map[string]*Entry)}
+	data, ferr := ioutil.ReadFile(path)
+	if os.IsNotExist(ferr) {
+		return table, nil
+	} else if ferr != nil {
+		return nil, ferr
+	}
+	jerr := json.Unmarshal(data, &table.Entries)
+	if jerr != nil {
+		return nil, jerr
+	}
+	return table, nil
+}
+
+// Save writes the table to its file
+func (t *Table) Save() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	data, merr := json.MarshalIndent(t.Entries, "", "    ")
+	if merr != nil {
+		return merr
+	}
+	return helpers.WriteFileAtomic(t.path, data, 0644)
+}
+
+// Get returns the entry for an image, nil if it has none
+func (t *Table) Get(imageName string) *Entry {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	entry, ok := t.Entries[imageName]
+	if !ok {
+		return nil
+	}
+	copied := *entry
+	return &copied
+}
+
+// Set replaces the entry for an image
+func (t *Table) Set(imageName string, entry Entry) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.Entries[imageName] = &entry
+}
+
+// Remove removes the entry for an image
+func (t *Table) Remove(imageName string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.Entries, imageName)
+}
@@ -0,0 +1,167 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bocajspear1/vmifactory/internal/helpers"
+)
+
+// KeyEnv is the environment variable that can hold the keystore key
+// instead of the key file, hex encoded
+const KeyEnv = "VMIF_KEYSTORE_KEY"
+
+const keySize = 32
+
+var nameRegex = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// ValidName checks if a keystore secret name is valid
+func ValidName(name string) bool {
+	return nameRegex.MatchString(name)
+}
+
+// Keystore is a file of secrets encrypted with AES-256-GCM. Each secret
+// has its own nonce and is bound to its name.
+type Keystore struct {
+	path string
+	aead cipher.AEAD
+	// Secrets are the encrypted secrets, base64 of the nonce and ciphertext
+	Secrets map[string]string `json:"secrets"`
+}
+
+// GenerateKey writes a new random keystore key to the key file, which must not exist yet
+func GenerateKey(keyPath string) error {
+	key := make([]byte, keySize)
+	_, rerr := rand.Read(key)
+	if rerr != nil {
+		return rerr
+	}
+	keyFile, ferr := os.OpenFile(keyPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if ferr != nil {
+		return errors.New("Could not create keystore key " + keyPath + ": " + ferr.Error())
+	}
+	defer keyFile.Close()
+	_, werr := keyFile.WriteString(hex.EncodeToString(key) + "\n")
+	if werr != nil {
+		return werr
+	}
+	return keyFile.Sync()
+}
+
+// readKey reads the key from KeyEnv or the key file
+func readKey(keyPath string) ([]byte, error) {
+	keyString, ok := os.LookupEnv(KeyEnv)
+	if !ok {
+		keyData, ferr := ioutil.ReadFile(keyPath)
+		if ferr != nil {
+			return nil, errors.New("Could not read keystore key " + keyPath + ", create one with 'vmif-run secret init': " + ferr.Error())
+		}
+		keyString = string(keyData)
+	}
+	key, herr := hex.DecodeString(strings.TrimSpace(keyString))
+	if herr != nil || len(key) != keySize {
+		return nil, errors.New("Keystore key must be 64 hex characters")
+	}
+	return key, nil
+}
+
+// OpenKeystore opens the keystore, an empty one if the file does not exist yet
+func OpenKeystore(path string, keyPath string) (*Keystore, error) {
+	key, kerr := readKey(keyPath)
+	if kerr != nil {
+		return nil, kerr
+	}
+	block, berr := aes.NewCipher(key)
+	if berr != nil {
+		return nil, berr
+	}
+	aead, gerr := cipher.NewGCM(block)
+	if gerr != nil {
+		return nil, gerr
+	}
+
+	keystore := &Keystore{path: path, aead: aead, Secrets: make(map[string]string)}
+	data, ferr := ioutil.ReadFile(path)
+	if os.IsNotExist(ferr) {
+		return keystore, nil
+	} else if ferr != nil {
+		return nil, ferr
+	}
+	jerr := json.Unmarshal(data, keystore)
+	if jerr != nil {
+		return nil, errors.New("Could not parse keystore " + path + ": " + jerr.Error())
+	}
+	if keystore.Secrets == nil {
+		keystore.Secrets = make(map[string]string)
+	}
+	return keystore, nil
+}
+
+// Get decrypts a secret
+func (k *Keystore) Get(name string) (string, error) {
+	encoded, ok := k.Secrets[name]
+	if !ok {
+		return "", errors.New("Secret '" + name + "' is not in the keystore")
+	}
+	sealed, derr := base64.StdEncoding.DecodeString(encoded)
+	if derr != nil || len(sealed) < k.aead.NonceSize() {
+		return "", errors.New("Secret '" + name + "' in the keystore is corrupt")
+	}
+	nonce := sealed[:k.aead.NonceSize()]
+	plain, oerr := k.aead.Open(nil, nonce, sealed[k.aead.NonceSize():], []byte(name))
+	if oerr != nil {
+		return "", errors.New("Could not decrypt secret '" + name + "', is the keystore key right?")
+	}
+	return string(plain), nil
+}
+
+// Set encrypts and stores a secret, call Save to write it
+func (k *Keystore) Set(name string, secret string) error {
+	if !ValidName(name) {
+		return errors.New("'" + name + "' is not a valid keystore name, use letters, digits, '.', '_' and '-'")
+	}
+	nonce := make([]byte, k.aead.NonceSize())
+	_, rerr := rand.Read(nonce)
+	if rerr != nil {
+		return rerr
+	}
+	sealed := k.aead.Seal(nonce, nonce, []byte(secret), []byte(name))
+	k.Secrets[name] = base64.StdEncoding.EncodeToString(sealed)
+	return nil
+}
+
+// Remove removes a secret, returning false if it was not there
+func (k *Keystore) Remove(name string) bool {
+	_, ok := k.Secrets[name]
+	delete(k.Secrets, name)
+	return ok
+}
+
+// Names returns the names of the secrets, sorted
+func (k *Keystore) Names() []string {
+	names := make([]string, 0, len(k.Secrets))
+	for name := range k.Secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Save writes the keystore to its file
+func (k *Keystore) Save() error {
+	data, merr := json.MarshalIndent(k, "", "    ")
+	if merr != nil {
+		return merr
+	}
+	return helpers.WriteFileAtomic(k.path, data, 0600)
+}
@@ -0,0 +1,83 @@
+// Package secrets resolves secret references in image configs. A value
+// may be a plain string, or refer to where the secret is kept:
+//
+//	env:NAME        the environment variable NAME
+//	file:/path      the contents of the file, without a trailing newline
+//	keystore:NAME   the secret NAME in the encrypted keystore
+//
+// References are only resolved when a build needs the value.
+package secrets
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/bocajspear1/vmifactory/internal/settings"
+)
+
+// Reference prefixes
+const (
+	PrefixEnv      = "env:"
+	PrefixFile     = "file:"
+	PrefixKeystore = "keystore:"
+)
+
+// IsReference checks if the value refers to a secret instead of being one
+func IsReference(value string) bool {
+	return strings.HasPrefix(value, PrefixEnv) || strings.HasPrefix(value, PrefixFile) || strings.HasPrefix(value, PrefixKeystore)
+}
+
+// CheckReference checks that a reference is well formed, without resolving it.
+// Plain values are always fine.
+func CheckReference(value string) error {
+	switch {
+	case strings.HasPrefix(value, PrefixEnv):
+		if value == PrefixEnv {
+			return errors.New("'" + PrefixEnv + "' reference needs a variable name")
+		}
+	case strings.HasPrefix(value, PrefixFile):
+		if value == PrefixFile {
+			return errors.New("'" + PrefixFile + "' reference needs a file path")
+		}
+	case strings.HasPrefix(value, PrefixKeystore):
+		if !ValidName(value[len(PrefixKeystore):]) {
+			return errors.New("'" + value + "' is not a valid keystore name, use letters, digits, '.', '_' and '-'")
+		}
+	}
+	return nil
+}
+
+// Resolve returns the secret a value refers to, or the value itself if
+// it is not a reference
+func Resolve(value string) (string, error) {
+	cerr := CheckReference(value)
+	if cerr != nil {
+		return "", cerr
+	}
+	switch {
+	case strings.HasPrefix(value, PrefixEnv):
+		name := value[len(PrefixEnv):]
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", errors.New("Environment variable '" + name + "' is not set")
+		}
+		return secret, nil
+	case strings.HasPrefix(value, PrefixFile):
+		path := value[len(PrefixFile):]
+		data, ferr := ioutil.ReadFile(path)
+		if ferr != nil {
+			return "", errors.New("Could not read secret file " + path + ": " + ferr.Error())
+		}
+		secret := strings.TrimSuffix(string(data), "\n")
+		return strings.TrimSuffix(secret, "\r"), nil
+	case strings.HasPrefix(value, PrefixKeystore):
+		keystore, kerr := OpenKeystore(settings.Get().KeystorePath, settings.Get().KeystoreKeyFile)
+		if kerr != nil {
+			return "", kerr
+		}
+		return keystore.Get(value[len(PrefixKeystore):])
+	}
+	return value, nil
+}
@@ -0,0 +1,280 @@
+// Package settings loads the global VMIFactory config file shared by
+// vmif-run and vmif-web. Every setting can be overridden by an environment
+// variable, so the binaries can run from any working directory.
+package settings
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/bocajspear1/vmifactory/internal/helpers"
+)
+
+// ConfigEnv is the environment variable with the path to the config file
+const ConfigEnv = "VMIF_CONFIG"
+
+// DefaultConfigPaths are where the config file is looked for if no path is given
+var DefaultConfigPaths = []string{"./vmifactory.json", "/etc/vmifactory/vmifactory.json"}
+
+// LogSettings are where the binaries log to
+type LogSettings struct {
+	// RunFile is the log file of vmif-run
+	RunFile string `json:"run_file"`
+	// WebFile is the log file of vmif-web
+	WebFile string `json:"web_file"`
+}
+
+// PublishSettings are how vmif-web serves the images
+type PublishSettings struct {
+	// Listen is the address:port to listen at
+	Listen string `json:"listen"`
+	// TemplatesDir has the page templates
+	TemplatesDir string `json:"templates_dir"`
+	// StaticDir has the files served under /static/
+	StaticDir string `json:"static_dir"`
+	// BaseURL is the address users reach the server at, like
+	// "https://images.example.com", for links that must be absolute. The
+	// address of each request is used if it is empty.
+	BaseURL string `json:"base_url"`
+}
+
+// ProxySettings are the proxies provisioning scripts use in the guest
+type ProxySettings struct {
+	HTTP    string `json:"http"`
+	HTTPS   string `json:"https"`
+	NoProxy string `json:"no_proxy"`
+}
+
+// Settings is the global config
+type Settings struct {
+	// ImageDir is the root directory holding the image directories
+	ImageDir string `json:"image_dir"`
+	// PackerPath is the Packer binary, looked up on the PATH if it has no directory
+	PackerPath string `json:"packer_path"`
+	// QemuImgPath is the qemu-img binary, looked up on the PATH if it has no directory
+	QemuImgPath string `json:"qemu_img_path"`
+	// WorkDir holds the work directories of builds, named after the images.
+	// Empty puts them in the image directories. It must be on the same
+	// filesystem as ImageDir, since outputs are moved out of it, which
+	// Load checks.
+	WorkDir string `json:"work_dir"`
+	// TempDir is used for temporary files by Packer and its plugins, empty for the system default
+	TempDir string `json:"temp_dir"`
+	// ProxmoxConfig is the Proxmox converter config file
+	ProxmoxConfig string `json:"proxmox_config"`
+	// KeystorePath is the encrypted keystore for 'keystore:' secrets
+	KeystorePath string `json:"keystore_path"`
+	// KeystoreKeyFile has the key of the keystore
+	KeystoreKeyFile string          `json:"keystore_key_file"`
+	Log             LogSettings     `json:"log"`
+	Publish         PublishSettings `json:"publish"`
+	// Env are environment variables for the provisioning scripts of every image
+	Env map[string]string `json:"env"`
+	// Proxy is passed to the provisioning scripts as http_proxy, https_proxy and no_proxy
+	Proxy ProxySettings `json:"proxy"`
+
+	// Path is the config file the settings were loaded from, empty if there was none
+	Path string `json:"-"`
+}
+
+// Default returns the settings used without a config file, which are
+// relative to the working directory
+func Default() *Settings {
+	return &Settings{
+		ImageDir:        "./images",
+		PackerPath:      "./packer",
+		QemuImgPath:     "qemu-img",
+		ProxmoxConfig:   "./config/proxmox.json",
+		KeystorePath:    "./keystore.json",
+		KeystoreKeyFile: "./keystore.key",
+		Log: LogSettings{
+			RunFile: "./vmif-run.log",
+			WebFile: "./vmif-web.log",
+		},
+		Publish: PublishSettings{
+			Listen:       ":8080",
+			TemplatesDir: "./web/templates",
+			StaticDir:    "./web/static",
+		},
+	}
+}
+
+// envOverrides maps the environment variables to the settings they override
+func (s *Settings) envOverrides() map[string]*string {
+	return map[string]*string{
+		"VMIF_IMAGE_DIR":         &s.ImageDir,
+		"VMIF_PACKER_PATH":       &s.PackerPath,
+		"VMIF_QEMU_IMG_PATH":     &s.QemuImgPath,
+		"VMIF_WORK_DIR":          &s.WorkDir,
+		"VMIF_TEMP_DIR":          &s.TempDir,
+		"VMIF_PROXMOX_CONFIG":    &s.ProxmoxConfig,
+		"VMIF_KEYSTORE":          &s.KeystorePath,
+		"VMIF_KEYSTORE_KEY_FILE": &s.KeystoreKeyFile,
+		"VMIF_RUN_LOG":           &s.Log.RunFile,
+		"VMIF_WEB_LOG":           &s.Log.WebFile,
+		"VMIF_LISTEN":            &s.Publish.Listen,
+		"VMIF_TEMPLATES_DIR":     &s.Publish.TemplatesDir,
+		"VMIF_STATIC_DIR":        &s.Publish.StaticDir,
+		"VMIF_BASE_URL":          &s.Publish.BaseURL,
+		"VMIF_HTTP_PROXY":        &s.Proxy.HTTP,
+		"VMIF_HTTPS_PROXY":       &s.Proxy.HTTPS,
+		"VMIF_NO_PROXY":          &s.Proxy.NoProxy,
+	}
+}
+
+// paths returns the settings that are file paths
+func (s *Settings) paths() []*string {
+	return []*string{
+		&s.ImageDir, &s.PackerPath, &s.QemuImgPath, &s.WorkDir, &s.TempDir,
+		&s.ProxmoxConfig, &s.KeystorePath, &s.KeystoreKeyFile, &s.Log.RunFile, &s.Log.WebFile,
+		&s.Publish.TemplatesDir, &s.Publish.StaticDir,
+	}
+}
+
+var envNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidEnvName checks if a name can be an environment variable for the
+// provisioning scripts. Names starting with VMIF_ are set by VMIFactory.
+func ValidEnvName(name string) bool {
+	return envNameRegex.MatchString(name) && !strings.HasPrefix(name, "VMIF_")
+}
+
+// commands returns the settings that are executables, found on the PATH
+// when they have no directory
+func (s *Settings) commands() []*string {
+	return []*string{&s.PackerPath, &s.QemuImgPath}
+}
+
+// resolvePath makes a relative path absolute against baseDir. Commands
+// without a directory, like "qemu-img", are left for the PATH lookup.
+func resolvePath(value string, baseDir string, command bool) string {
+	if value == "" || filepath.IsAbs(value) || (command && !strings.ContainsAny(value, "/\\")) {
+		return value
+	}
+	return filepath.Join(baseDir, value)
+}
+
+// Load loads the settings. The config file is the given path, the
+// VMIF_CONFIG environment variable or the first of DefaultConfigPaths
+// that exists. Without one the defaults are used. With a config file,
+// relative paths are relative to its directory, including defaults it
+// does not set. Environment variables are relative to the working directory.
+func Load(path string) (*Settings, error) {
+	s := Default()
+
+	if path == "" {
+		path = os.Getenv(ConfigEnv)
+	}
+	if path == "" {
+		for _, defaultPath := range DefaultConfigPaths {
+			if _, serr := os.Stat(defaultPath); serr == nil {
+				path = defaultPath
+				break
+			}
+		}
+	}
+
+	cwd, cerr := os.Getwd()
+	if cerr != nil {
+		return nil, cerr
+	}
+	baseDir := cwd
+
+	if path != "" {
+		configData, ferr := ioutil.ReadFile(path)
+		if ferr != nil {
+			return nil, errors.New("Could not read config file " + path + ": " + ferr.Error())
+		}
+		decoder := json.NewDecoder(bytes.NewReader(configData))
+		decoder.DisallowUnknownFields()
+		jerr := decoder.Decode(s)
+		if jerr != nil {
+			return nil, errors.New("Could not parse config file " + path + ": " + jerr.Error())
+		}
+		absPath, _ := filepath.Abs(path)
+		s.Path = absPath
+		baseDir = filepath.Dir(absPath)
+	}
+
+	isCommand := make(map[*string]bool)
+	for _, value := range s.commands() {
+		isCommand[value] = true
+	}
+	for _, value := range s.paths() {
+		*value = resolvePath(*value, baseDir, isCommand[value])
+	}
+
+	// The environment wins over the file, its paths are relative to the
+	// working directory
+	isPath := make(map[*string]bool)
+	for _, value := range s.paths() {
+		isPath[value] = true
+	}
+	for name, value := range s.envOverrides() {
+		envValue, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if isPath[value] {
+			envValue = resolvePath(envValue, cwd, isCommand[value])
+		}
+		*value = envValue
+	}
+
+	if s.ImageDir == "" {
+		return nil, errors.New("Setting 'image_dir' must not be empty")
+	}
+	if s.WorkDir != "" {
+		// Builds are committed by renaming, which cannot cross filesystems
+		same, ferr := helpers.SameFilesystem(s.WorkDir, s.ImageDir)
+		if ferr != nil {
+			return nil, errors.New("Could not check setting 'work_dir': " + ferr.Error())
+		}
+		if !same {
+			return nil, errors.New("Setting 'work_dir' " + s.WorkDir + " must be on the same filesystem as 'image_dir' " + s.ImageDir)
+		}
+	}
+	if s.Publish.BaseURL != "" && !strings.HasPrefix(s.Publish.BaseURL, "http://") && !strings.HasPrefix(s.Publish.BaseURL, "https://") {
+		return nil, errors.New("Setting 'publish'/'base_url' must start with http:// or https://")
+	}
+	for name := range s.Env {
+		if !ValidEnvName(name) {
+			return nil, errors.New("Setting 'env' has invalid variable name '" + name + "'")
+		}
+	}
+	return s, nil
+}
+
+var (
+	currentLock sync.Mutex
+	current     *Settings
+)
+
+// Init loads the settings and makes them the current settings
+func Init(path string) (*Settings, error) {
+	s, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	currentLock.Lock()
+	defer currentLock.Unlock()
+	current = s
+	return s, nil
+}
+
+// Get returns the current settings, the defaults if none were loaded
+func Get() *Settings {
+	currentLock.Lock()
+	defer currentLock.Unlock()
+	if current == nil {
+		current = Default()
+	}
+	return current
+}
@@ -0,0 +1,122 @@
+package settings
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bocajspear1/vmifactory/internal/helpers"
+)
+
+// writeConfig writes a config file in a new directory, returning its path
+func writeConfig(t *testing.T, config string) string {
+	t.Helper()
+	path := t.TempDir() + "/vmifactory.json"
+	werr := ioutil.WriteFile(path, []byte(config), 0644)
+	if werr != nil {
+		t.Fatal(werr)
+	}
+	return path
+}
+
+func TestLoadWorkDirSameFilesystem(t *testing.T) {
+	// The work directory does not have to exist yet
+	path := writeConfig(t, `{"image_dir": "images", "work_dir": "work/not/yet"}`)
+	s, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !strings.HasSuffix(s.WorkDir, "/work/not/yet") {
+		t.Errorf("WorkDir = %q, want it relative to the config file", s.WorkDir)
+	}
+}
+
+// otherFilesystemDir returns a directory on another filesystem than the
+// test's temporary directories, skipping the test if there is none
+func otherFilesystemDir(t *testing.T) string {
+	t.Helper()
+	// tmpfs is the usual other filesystem for a work directory
+	for _, candidate := range []string{"/dev/shm", "/run", "/tmp"} {
+		info, serr := os.Stat(candidate)
+		if serr != nil || !info.IsDir() {
+			continue
+		}
+		same, ferr := helpers.SameFilesystem(candidate, t.TempDir())
+		if ferr == nil && !same {
+			return candidate
+		}
+	}
+	t.Skip("no directory on another filesystem")
+	return ""
+}
+
+// chdir changes the working directory for the rest of the test
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	oldDir, gerr := os.Getwd()
+	if gerr != nil {
+		t.Fatal(gerr)
+	}
+	cerr := os.Chdir(dir)
+	if cerr != nil {
+		t.Fatal(cerr)
+	}
+	t.Cleanup(func() { os.Chdir(oldDir) })
+}
+
+func TestLoadRelativePaths(t *testing.T) {
+	path := writeConfig(t, `{"image_dir": "images", "packer_path": "packer", "qemu_img_path": "bin/qemu-img"}`)
+	configDir := filepath.Dir(path)
+	chdir(t, t.TempDir())
+	s, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if s.ImageDir != configDir+"/images" {
+		t.Errorf("ImageDir = %q, want it relative to the config file", s.ImageDir)
+	}
+	if s.PackerPath != "packer" {
+		t.Errorf("PackerPath = %q, want it left for the PATH lookup", s.PackerPath)
+	}
+	if s.QemuImgPath != configDir+"/bin/qemu-img" {
+		t.Errorf("QemuImgPath = %q, want it relative to the config file", s.QemuImgPath)
+	}
+}
+
+func TestLoadRelativeEnvWorkDir(t *testing.T) {
+	path := writeConfig(t, `{"image_dir": "images"}`)
+	configDir := filepath.Dir(path)
+	cwd := t.TempDir()
+	chdir(t, cwd)
+	t.Setenv("VMIF_WORK_DIR", "work")
+	s, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if s.WorkDir != cwd+"/work" {
+		t.Errorf("WorkDir = %q, want it relative to the working directory", s.WorkDir)
+	}
+	if s.ImageDir != configDir+"/images" {
+		t.Errorf("ImageDir = %q, want it relative to the config file", s.ImageDir)
+	}
+
+	// The relative work_dir from the environment is checked where it
+	// resolves, not next to the config file
+	chdir(t, otherFilesystemDir(t))
+	_, err = Load(path)
+	if err == nil || !strings.Contains(err.Error(), "must be on the same filesystem") {
+		t.Errorf("Load() error = %v, want a work_dir filesystem error", err)
+	}
+}
+
+func TestLoadWorkDirOtherFilesystem(t *testing.T) {
+	otherDir := otherFilesystemDir(t)
+
+	path := writeConfig(t, `{"image_dir": "images", "work_dir": "`+otherDir+`/vmif-work"}`)
+	_, err := Load(path)
+	if err == nil || !strings.Contains(err.Error(), "must be on the same filesystem") {
+		t.Errorf("Load() error = %v, want a work_dir filesystem error", err)
+	}
+}